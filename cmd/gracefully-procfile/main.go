@@ -0,0 +1,249 @@
+//go:build !windows
+// +build !windows
+
+// Command gracefully-procfile runs the processes listed in a Procfile, like foreman, with the lifecycle of a gracefully
+// service:
+//
+//	gracefully-procfile --restart=on-failure --stop-timeout=10s -f Procfile
+//
+// Each line of the Procfile is "name: command", the command being run with /bin/sh -c. The output of every process is
+// prefixed with its name, in color on a terminal. A process that exits is restarted according to --restart, once it's not,
+// all the others are stopped too.
+//
+// SIGINT and SIGTERM stop the processes in the reverse order of the Procfile, each one is sent --stop-signal and waited
+// for before the next one. They all share --stop-timeout: once it's up, those still running are sent SIGKILL. SIGHUP
+// stops then starts them all again. The --forward signals are relayed to every process. Once done, gracefully-procfile
+// exits with the exit status of the process that ended the run, if any.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/wojnosystems/gracefully"
+	"github.com/wojnosystems/gracefully/internal/cli"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout))
+}
+
+// run supervises the processes of the Procfile described by args, writing their output to stdout, and returns the exit status
+func run(args []string, stdout io.Writer) int {
+	logger := log.New(os.Stderr, "gracefully-procfile: ", 0)
+	fs := flag.NewFlagSet("gracefully-procfile", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gracefully-procfile [flags]")
+		fs.PrintDefaults()
+	}
+	var restartFlags cli.RestartFlags
+	restartFlags.Register(fs)
+	procfile := fs.String("f", "Procfile", "path to the Procfile")
+	stopTimeout := fs.Duration("stop-timeout", 30*time.Second, "how long all the processes get to exit, in total, before those left are killed")
+	stopSignal := fs.String("stop-signal", "TERM", "signal sent to each process to stop it")
+	forward := fs.String("forward", "USR1,USR2,WINCH", "comma-separated signals relayed to every process")
+	noColor := fs.Bool("no-color", false, "do not colorize the output, even on a terminal")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+
+	policy, err := restartFlags.Restarts()
+	if err != nil {
+		logger.Println(err)
+		return 2
+	}
+	opts := gracefully.CommandOptions{StopTimeout: *stopTimeout}
+	if opts.StopSignal, err = cli.ParseSignal(*stopSignal); err != nil {
+		logger.Println(err)
+		return 2
+	}
+	if opts.ForwardSignals, err = cli.ParseSignals(*forward); err != nil {
+		logger.Println(err)
+		return 2
+	}
+
+	f, err := os.Open(*procfile)
+	if err != nil {
+		logger.Println(err)
+		return 2
+	}
+	entries, err := parseProcfile(f)
+	_ = f.Close()
+	if err != nil {
+		logger.Printf("%s: %v", *procfile, err)
+		return 2
+	}
+
+	names := []string{systemName}
+	for _, e := range entries {
+		names = append(names, e.name)
+	}
+	r := &runner{
+		entries: entries,
+		policy:  policy,
+		opts:    opts,
+		out:     newOutput(stdout, !*noColor && isTerminal(stdout), names),
+	}
+	r.opts.Forwarder = gracefully.NewForwarder(func(e gracefully.Event) {
+		r.system("%s: %v", e.Message, e.Err)
+	})
+
+	sm := gracefully.New()
+	sm.AddSignaler(gracefully.DefaultForwardingSignals(r.opts.Forwarder, r.opts.ForwardSignals...))
+	// The processes have their own restart policy, once one gives up, we're done
+	sm.SetRestartPolicy(gracefully.Restarts{Mode: gracefully.RestartNever})
+	err = sm.Run(r.routine)
+	if errors.Is(err, gracefully.ErrAbandoned) {
+		// Forced to stop, the processes must not outlive us
+		r.opts.Forwarder.Broadcast(syscall.SIGKILL)
+	}
+	return cli.ErrorCode(err)
+}
+
+// systemName is the name our own messages are prefixed with
+const systemName = "system"
+
+// runner runs the entries of a Procfile, each one supervised by its own ServiceManager
+type runner struct {
+	entries []entry
+	policy  gracefully.Restarts
+	// opts are shared by every process, including the Forwarder
+	opts gracefully.CommandOptions
+	out  *output
+}
+
+// process is an entry that was started
+type process struct {
+	entry
+	index   int
+	control *gracefully.ContextSignal
+	manager *gracefully.ServiceManager
+	// exited is true once its ServiceManager is done
+	exited bool
+	// stopped is true once it was asked to stop
+	stopped bool
+}
+
+// exit is how the ServiceManager of the index-th process ended
+type exit struct {
+	index int
+	err   error
+}
+
+// system writes a message of our own
+func (r *runner) system(format string, args ...interface{}) {
+	r.out.Printf(0, systemName, format, args...)
+}
+
+// routine starts every process, then stops them all, in reverse order, once asked to or once one of them is done for good
+func (r *runner) routine(ctx context.Context) (err error) {
+	processes := make([]*process, len(r.entries))
+	exits := make(chan exit, len(r.entries))
+	for i, e := range r.entries {
+		processes[i] = r.start(i, e, exits)
+	}
+
+	select {
+	case <-ctx.Done():
+	case x := <-exits:
+		processes[x.index].exited = true
+		r.system("%s is done: %v, stopping everything", processes[x.index].name, describe(x.err))
+		err = x.err
+	}
+
+	r.stopAll(processes, exits)
+	return err
+}
+
+// start starts the entry as the index-th process, its ServiceManager's result is sent to exits
+func (r *runner) start(index int, e entry, exits chan<- exit) *process {
+	p := &process{
+		entry:   e,
+		index:   index,
+		control: gracefully.NewContextSignal(),
+		manager: gracefully.New(),
+	}
+	// Colors are handed out after the system's
+	stdout := r.out.newLineWriter(index+1, e.name)
+	stderr := r.out.newLineWriter(index+1, e.name)
+	cmd := exec.Command("/bin/sh", "-c", e.command)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	opts := r.opts
+	opts.OnExit = func(state *os.ProcessState) {
+		// Output is all copied by the time the process is reaped
+		stdout.Flush()
+		stderr.Flush()
+		r.out.Printf(index+1, e.name, "exited: %v", state)
+	}
+	p.manager.AddSignaler(p.control)
+	p.manager.SetRestartPolicy(r.policy)
+	r.out.Printf(index+1, e.name, "starting: %s", e.command)
+	go func() {
+		exits <- exit{index: index, err: p.manager.Run(gracefully.Command(cmd, opts))}
+	}()
+	return p
+}
+
+// stopAll stops the processes one at a time, last one first. Once StopTimeout is up, the ones left are killed
+func (r *runner) stopAll(processes []*process, exits <-chan exit) {
+	deadline := time.NewTimer(r.opts.StopTimeout)
+	defer deadline.Stop()
+	for i := len(processes) - 1; i >= 0; i-- {
+		if processes[i].exited {
+			continue
+		}
+		r.stop(processes[i])
+		for !processes[i].exited {
+			select {
+			case x := <-exits:
+				processes[x.index].exited = true
+			case <-deadline.C:
+				r.kill(processes)
+			}
+		}
+	}
+}
+
+// stop asks the process to stop, once
+func (r *runner) stop(p *process) {
+	if p.stopped {
+		return
+	}
+	p.stopped = true
+	// Buffered, and only sent once, so this can't block even if its ServiceManager is already done
+	p.control.Stop()
+}
+
+// kill sends SIGKILL to all the processes left. Their ServiceManagers are forced to stop first, so that they won't
+// restart them, and so that their exits are sent right away
+func (r *runner) kill(processes []*process) {
+	r.system("stop timeout is up, killing the processes left")
+	for _, p := range processes {
+		if !p.exited {
+			p.manager.ForceStop()
+		}
+	}
+	r.opts.Forwarder.Broadcast(syscall.SIGKILL)
+}
+
+// describe is how a process ended, for humans
+func describe(err error) string {
+	if err == nil {
+		return "exited successfully"
+	}
+	return err.Error()
+}
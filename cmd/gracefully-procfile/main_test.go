@@ -0,0 +1,121 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a bytes.Buffer that can be read while run writes to it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// writeProcfile writes a Procfile with content and returns its path
+func writeProcfile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "Procfile")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// waitForOutput waits until out holds every one of lines
+func waitForOutput(t *testing.T, out *syncBuffer, lines ...string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		missing := ""
+		for _, line := range lines {
+			if !strings.Contains(out.String(), line) {
+				missing = line
+			}
+		}
+		if missing == "" {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected output ", missing, " got: ", out.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRun_PrefixesOutputAndExitsWithFirstExit(t *testing.T) {
+	// job only exits once web had its say
+	ready := filepath.Join(t.TempDir(), "ready")
+	procfile := writeProcfile(t, "web: echo from web; touch "+ready+"; sleep 10\n"+
+		"job: while [ ! -f "+ready+" ]; do sleep 0.01; done; echo from job; exit 3\n")
+	out := &syncBuffer{}
+	started := time.Now()
+	if code := run([]string{"-f", procfile}, out); code != 3 {
+		t.Error("expected the exit status of job, got: ", code)
+	}
+	if time.Since(started) > 5*time.Second {
+		t.Error("expected web to be stopped once job was done")
+	}
+	// Prefixes are as wide as the longest name, system
+	waitForOutput(t, out, "web    | from web\n", "job    | from job\n", "system | job is done")
+}
+
+func TestRun_StopsInReverseOrder(t *testing.T) {
+	// Only up once the trap is set, SIGTERM would kill it otherwise
+	script := "trap 'echo stopping; exit 0' TERM; echo up; while true; do sleep 0.01; done"
+	procfile := writeProcfile(t, "first: "+script+"\nsecond: "+script+"\n")
+	out := &syncBuffer{}
+	code := make(chan int, 1)
+	go func() {
+		code <- run([]string{"-f", procfile}, out)
+	}()
+	waitForOutput(t, out, "first  | up\n", "second | up\n")
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case c := <-code:
+		if c != 0 {
+			t.Error("expected a clean stop, got: ", c)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected SIGTERM to stop everything")
+	}
+	output := out.String()
+	if first, second := strings.Index(output, "first  | stopping"), strings.Index(output, "second | stopping"); second < 0 || first < second {
+		t.Error("expected second to be stopped before first, got: ", output)
+	}
+}
+
+func TestRun_KillsOnceStopTimeoutIsUp(t *testing.T) {
+	procfile := writeProcfile(t, "stubborn: trap '' TERM; sleep 10\ndone: sleep 0.1\n")
+	out := &syncBuffer{}
+	started := time.Now()
+	if code := run([]string{"-f", procfile, "--stop-timeout=200ms"}, out); code != 0 {
+		t.Error("expected the exit status of done, got: ", code)
+	}
+	if time.Since(started) > 5*time.Second {
+		t.Error("expected stubborn to be killed")
+	}
+	waitForOutput(t, out, "stop timeout is up", "signal: killed")
+}
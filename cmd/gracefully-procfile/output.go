@@ -0,0 +1,111 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// colors are the ANSI colors given to the processes, in turn
+var colors = []int{36, 33, 32, 35, 34, 31, 96, 93, 92, 95, 94, 91}
+
+// output interleaves the lines written by every process, so that they are never mixed up mid-line
+type output struct {
+	mu sync.Mutex
+	w  io.Writer
+	// color is true if prefixes are colorized
+	color bool
+	// width is the width of the longest name, so that the prefixes line up
+	width int
+}
+
+// newOutput creates an output writing to w, sized for names
+func newOutput(w io.Writer, color bool, names []string) *output {
+	o := &output{w: w, color: color}
+	for _, name := range names {
+		if len(name) > o.width {
+			o.width = len(name)
+		}
+	}
+	return o
+}
+
+// isTerminal is true if w is a terminal, where colors make sense
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// prefix is what precedes each line of the index-th process called name
+func (o *output) prefix(index int, name string) string {
+	p := fmt.Sprintf("%-*s | ", o.width, name)
+	if o.color {
+		p = fmt.Sprintf("\x1b[%dm%s\x1b[0m", colors[index%len(colors)], p)
+	}
+	return p
+}
+
+// writeLine writes a complete line, prefixed
+func (o *output) writeLine(prefix string, line []byte) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	// Nothing sensible to do if our own output is gone
+	_, _ = io.WriteString(o.w, prefix)
+	_, _ = o.w.Write(line)
+}
+
+// Printf writes a line of our own, for the process called name
+func (o *output) Printf(index int, name string, format string, args ...interface{}) {
+	o.writeLine(o.prefix(index, name), []byte(fmt.Sprintf(format+"\n", args...)))
+}
+
+// lineWriter is the io.Writer given to a process as its stdout or stderr, it prefixes every line it writes
+type lineWriter struct {
+	out    *output
+	prefix string
+	// mu protects pending
+	mu sync.Mutex
+	// pending is what was written after the last newline
+	pending []byte
+}
+
+// newLineWriter creates a lineWriter for the index-th process called name
+func (o *output) newLineWriter(index int, name string) *lineWriter {
+	return &lineWriter{out: o, prefix: o.prefix(index, name)}
+}
+
+// Write writes the complete lines of b, the rest is kept until its newline shows up
+func (l *lineWriter) Write(b []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.pending = append(l.pending, b...)
+	for {
+		i := bytes.IndexByte(l.pending, '\n')
+		if i < 0 {
+			break
+		}
+		l.out.writeLine(l.prefix, l.pending[:i+1])
+		l.pending = l.pending[i+1:]
+	}
+	return len(b), nil
+}
+
+// Flush writes what's left without a newline, once the process exited
+func (l *lineWriter) Flush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.pending) == 0 {
+		return
+	}
+	l.out.writeLine(l.prefix, append(l.pending, '\n'))
+	l.pending = nil
+}
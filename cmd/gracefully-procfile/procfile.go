@@ -0,0 +1,58 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// entry is a process listed in a Procfile
+type entry struct {
+	// name identifies the process in the output
+	name string
+	// command is run with /bin/sh -c
+	command string
+}
+
+// entryLine matches a Procfile line, the same way foreman does
+var entryLine = regexp.MustCompile(`^([A-Za-z0-9_-]+):\s*(.+)$`)
+
+// parseProcfile reads the entries of a Procfile, in order. Blank lines and lines starting with # are ignored. No entry
+// can be named systemName, its output could not be told apart from ours
+func parseProcfile(r io.Reader) ([]entry, error) {
+	entries := make([]entry, 0)
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		match := entryLine.FindStringSubmatch(line)
+		if match == nil {
+			return nil, fmt.Errorf("line %d: expected name: command", lineNumber)
+		}
+		if match[1] == systemName {
+			return nil, fmt.Errorf("line %d: %s is reserved for our own messages", lineNumber, systemName)
+		}
+		if seen[match[1]] {
+			return nil, fmt.Errorf("line %d: duplicate name: %s", lineNumber, match[1])
+		}
+		seen[match[1]] = true
+		entries = append(entries, entry{name: match[1], command: strings.TrimSpace(match[2])})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no processes listed")
+	}
+	return entries, nil
+}
@@ -0,0 +1,36 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseProcfile(t *testing.T) {
+	entries, err := parseProcfile(strings.NewReader("# dev stack\n\nweb: ./server --port 8080\nworker:./worker -q default\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []entry{
+		{name: "web", command: "./server --port 8080"},
+		{name: "worker", command: "./worker -q default"},
+	}
+	if len(entries) != len(expected) {
+		t.Fatal("expected 2 entries, got: ", entries)
+	}
+	for i := range expected {
+		if entries[i] != expected[i] {
+			t.Error("expected ", expected[i], " got: ", entries[i])
+		}
+	}
+}
+
+func TestParseProcfile_Invalid(t *testing.T) {
+	for _, procfile := range []string{"", "web ./server\n", "web: a\nweb: b\n", "system: ./server\n"} {
+		if _, err := parseProcfile(strings.NewReader(procfile)); err == nil {
+			t.Error("expected an error for: ", procfile)
+		}
+	}
+}
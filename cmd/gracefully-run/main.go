@@ -0,0 +1,113 @@
+//go:build !windows
+// +build !windows
+
+// Command gracefully-run supervises a single process with the same lifecycle as a gracefully service, which makes it a
+// good container entrypoint for programs that are not written in Go:
+//
+//	gracefully-run --restart=on-failure --backoff=exp --stop-timeout=30s -- ./server args
+//
+// SIGINT and SIGTERM stop the process, sending it --stop-signal then SIGKILL after --stop-timeout. SIGHUP restarts it,
+// or sends it --reload-signal if set. The --forward signals are relayed as-is. Once done, gracefully-run exits with the
+// exit status of the process, or 128 plus the signal that terminated it.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/wojnosystems/gracefully"
+	"github.com/wojnosystems/gracefully/internal/cli"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+// run supervises the process described by args and returns the exit status
+func run(args []string) int {
+	logger := log.New(os.Stderr, "gracefully-run: ", 0)
+	fs := flag.NewFlagSet("gracefully-run", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gracefully-run [flags] -- command [args...]")
+		fs.PrintDefaults()
+	}
+	var restartFlags cli.RestartFlags
+	restartFlags.Register(fs)
+	stopTimeout := fs.Duration("stop-timeout", 30*time.Second, "how long the process gets to exit after --stop-signal before it's killed")
+	stopSignal := fs.String("stop-signal", "TERM", "signal sent to the process to stop it")
+	reloadSignal := fs.String("reload-signal", "", "signal sent to the process on SIGHUP instead of restarting it")
+	forward := fs.String("forward", "USR1,USR2,WINCH", "comma-separated signals relayed to the process")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	policy, err := restartFlags.Restarts()
+	if err != nil {
+		logger.Println(err)
+		return 2
+	}
+	opts := gracefully.CommandOptions{StopTimeout: *stopTimeout}
+	if opts.StopSignal, err = cli.ParseSignal(*stopSignal); err != nil {
+		logger.Println(err)
+		return 2
+	}
+	if *reloadSignal != "" {
+		if opts.RestartSignal, err = cli.ParseSignal(*reloadSignal); err != nil {
+			logger.Println(err)
+			return 2
+		}
+	}
+	if opts.ForwardSignals, err = cli.ParseSignals(*forward); err != nil {
+		logger.Println(err)
+		return 2
+	}
+
+	opts.Forwarder = gracefully.NewForwarder(func(e gracefully.Event) {
+		logger.Printf("%s: %v", e.Message, e.Err)
+	})
+	// The last time the process exited is what we report as our own exit status
+	var mu sync.Mutex
+	var last *os.ProcessState
+	opts.OnExit = func(state *os.ProcessState) {
+		mu.Lock()
+		defer mu.Unlock()
+		last = state
+	}
+
+	cmd := exec.Command(fs.Arg(0), fs.Args()[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	sm := gracefully.New()
+	sm.AddSignaler(gracefully.DefaultForwardingSignals(opts.Forwarder, opts.ForwardSignals...))
+	sm.SetRestartPolicy(policy)
+	err = sm.Run(gracefully.Command(cmd, opts))
+	if errors.Is(err, gracefully.ErrAbandoned) {
+		// Forced to stop, the child must not outlive us
+		opts.Forwarder.Broadcast(syscall.SIGKILL)
+	}
+
+	var exitErr *gracefully.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		logger.Println(err)
+		return cli.StartFailureCode(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if last == nil {
+		return 0
+	}
+	return cli.ExitCode(last)
+}
@@ -0,0 +1,35 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"testing"
+)
+
+func TestRun_ExitCodes(t *testing.T) {
+	cases := []struct {
+		args     []string
+		expected int
+	}{
+		// Usage errors
+		{nil, 2},
+		{[]string{"--no-such-flag", "--", "true"}, 2},
+		{[]string{"--stop-signal=NOPE", "--", "true"}, 2},
+		{[]string{"--reload-signal=NOPE", "--", "true"}, 2},
+		{[]string{"--forward=USR1,NOPE", "--", "true"}, 2},
+		{[]string{"--restart=sometimes", "--", "true"}, 2},
+		// How the process ended
+		{[]string{"--", "true"}, 0},
+		{[]string{"--", "sh", "-c", "exit 3"}, 3},
+		{[]string{"--", "sh", "-c", "kill -KILL $$"}, 137},
+		{[]string{"--", "./no-such-command"}, 127},
+		// Restarted until it gives up, the last exit is reported
+		{[]string{"--restart=on-failure", "--backoff=none", "--max-restarts=2", "--", "sh", "-c", "exit 4"}, 4},
+	}
+	for _, c := range cases {
+		if code := run(c.args); code != c.expected {
+			t.Error("expected ", c.expected, " for ", c.args, " got: ", code)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+//go:build !windows
+// +build !windows
+
+package gracefully
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// CommandOptions configures the routine created by Command
+type CommandOptions struct {
+	// StopSignal is sent to the process group when the routine is asked to stop. Defaults to SIGTERM
+	StopSignal os.Signal
+	// StopTimeout is how long the process gets to exit after StopSignal before it's sent SIGKILL. Defaults to 30 seconds
+	StopTimeout time.Duration
+	// RestartSignal, if set, is sent to the process group on GracefulRestart and the process is kept running, for
+	// programs that reload themselves, such as nginx with SIGHUP. Otherwise, the process is stopped and started again
+	RestartSignal os.Signal
+	// Forwarder, if set, has the process group registered to receive ForwardSignals while the process is running
+	Forwarder *Forwarder
+	// ForwardSignals are the signals the Forwarder relays to this process group
+	ForwardSignals []os.Signal
+	// OnExit, if set, is called every time the process exits, whatever the reason, even when it was asked to stop
+	OnExit func(state *os.ProcessState)
+}
+
+// ExitError is returned by the Command routine when the process exited on its own, or had to be killed
+type ExitError struct {
+	// Code is the exit status of the process, -1 if it was terminated by a signal
+	Code int
+	// Signal is the signal that terminated the process, nil if it exited
+	Signal os.Signal
+	// Err is the error returned by exec.Cmd.Wait
+	Err error
+}
+
+// Error describes how the process ended
+func (e *ExitError) Error() string {
+	if e.Signal != nil {
+		return fmt.Sprintf("gracefully: process terminated by signal: %v", e.Signal)
+	}
+	return fmt.Sprintf("gracefully: process exited with code %d", e.Code)
+}
+
+// Unwrap gets the error returned by exec.Cmd.Wait
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// Command creates a routine for ServiceManager.Start or Run that runs cmd as a child process, in its own process group.
+// When the routine is asked to stop, the group is sent StopSignal, then SIGKILL if it's still running after StopTimeout.
+// On GracefulRestart, the process is either sent RestartSignal, or stopped and started again.
+//
+// If the process exits on its own with status 0, nil is returned and the ServiceManager starts it again, any other
+// status is returned as an *ExitError, as is being killed after StopTimeout. When stopped, being terminated by StopSignal,
+// or exiting with 128 plus StopSignal as shells do, is a clean exit too.
+// An exec.Cmd can only run once, so cmd is used as a template for each process started. Its Cancel function is not
+// used, nor is the context it was created with, if any: the process is stopped with StopSignal instead
+func Command(cmd *exec.Cmd, opts CommandOptions) func(ctx context.Context) error {
+	if opts.StopSignal == nil {
+		opts.StopSignal = syscall.SIGTERM
+	}
+	if opts.StopTimeout <= 0 {
+		opts.StopTimeout = defaultShutdownTimeout
+	}
+	c := &command{
+		template: cmd,
+		opts:     opts,
+	}
+	return c.run
+}
+
+// command supervises the processes started from template. A process outlives the iteration when restarted with RestartSignal
+type command struct {
+	template *exec.Cmd
+	opts     CommandOptions
+	// mu protects all fields below
+	mu sync.Mutex
+	// process is the running child, nil if none
+	process *exec.Cmd
+	// exited receives the result of Wait for process
+	exited chan error
+}
+
+// run is the routine: it starts the process if it's not already running and supervises it until ctx is done
+func (c *command) run(ctx context.Context) error {
+	c.mu.Lock()
+	if c.process == nil {
+		if err := c.start(); err != nil {
+			c.mu.Unlock()
+			return err
+		}
+	}
+	exited := c.exited
+	c.mu.Unlock()
+
+	select {
+	case err := <-exited:
+		c.clear()
+		return exitError(err)
+	case <-ctx.Done():
+	}
+
+	if c.opts.RestartSignal != nil && isRestarting(ctx) {
+		// If the signal could not be sent, the process is most likely gone, we'll find out by stopping it
+		if err := c.signal(c.opts.RestartSignal); err == nil {
+			return nil
+		}
+	}
+	return c.stop(exited)
+}
+
+// start starts a new process from the template, in its own process group. Caller must hold mu
+func (c *command) start() error {
+	// exec.Command could not find the executable
+	if c.template.Err != nil {
+		return c.template.Err
+	}
+	process := &exec.Cmd{
+		Path:        c.template.Path,
+		Args:        c.template.Args,
+		Env:         c.template.Env,
+		Dir:         c.template.Dir,
+		Stdin:       c.template.Stdin,
+		Stdout:      c.template.Stdout,
+		Stderr:      c.template.Stderr,
+		ExtraFiles:  c.template.ExtraFiles,
+		WaitDelay:   c.template.WaitDelay,
+		SysProcAttr: &syscall.SysProcAttr{},
+	}
+	if c.template.SysProcAttr != nil {
+		*process.SysProcAttr = *c.template.SysProcAttr
+	}
+	// In its own group, so that signals reach its children too, and so that a Ctrl-C on our terminal is ours to handle
+	process.SysProcAttr.Setpgid = true
+	process.SysProcAttr.Pgid = 0
+	if err := process.Start(); err != nil {
+		return err
+	}
+	c.process = process
+	c.exited = make(chan error, 1)
+	if c.opts.Forwarder != nil {
+		c.opts.Forwarder.Register(process.Process.Pid, true, c.opts.ForwardSignals...)
+	}
+	go func(exited chan<- error) {
+		err := process.Wait()
+		if c.opts.Forwarder != nil {
+			c.opts.Forwarder.Unregister(process.Process.Pid)
+		}
+		if c.opts.OnExit != nil && process.ProcessState != nil {
+			c.opts.OnExit(process.ProcessState)
+		}
+		exited <- err
+	}(c.exited)
+	return nil
+}
+
+// stop sends StopSignal to the process group, then SIGKILL if it has not exited within StopTimeout
+func (c *command) stop(exited <-chan error) error {
+	_ = c.signal(c.opts.StopSignal)
+	timer := time.NewTimer(c.opts.StopTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-exited:
+		c.clear()
+		if c.stoppedBy(err) {
+			return nil
+		}
+		// It failed for reasons of its own
+		return exitError(err)
+	case <-timer.C:
+	}
+	_ = c.signal(syscall.SIGKILL)
+	err := <-exited
+	c.clear()
+	return exitError(err)
+}
+
+// stoppedBy is true if err, from exec.Cmd.Wait, says the process exited cleanly, or because of StopSignal
+func (c *command) stoppedBy(err error) bool {
+	var exitErr *ExitError
+	if err = exitError(err); err == nil || !errors.As(err, &exitErr) {
+		return err == nil
+	}
+	stopSignal, ok := c.opts.StopSignal.(syscall.Signal)
+	if !ok {
+		return false
+	}
+	return exitErr.Signal == stopSignal || exitErr.Code == 128+int(stopSignal)
+}
+
+// signal sends sig to the process group of the running process
+func (c *command) signal(sig os.Signal) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.process == nil {
+		return os.ErrProcessDone
+	}
+	sysSig, ok := sig.(syscall.Signal)
+	if !ok {
+		return c.process.Process.Signal(sig)
+	}
+	return syscall.Kill(-c.process.Process.Pid, sysSig)
+}
+
+// clear forgets the process, once it exited
+func (c *command) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.process = nil
+	c.exited = nil
+}
+
+// exitError converts the error from exec.Cmd.Wait to an *ExitError, or nil if the process exited with status 0
+func exitError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return err
+	}
+	e := &ExitError{
+		Code: exitErr.ExitCode(),
+		Err:  err,
+	}
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		e.Signal = status.Signal()
+	}
+	return e
+}
@@ -0,0 +1,98 @@
+//go:build !windows
+// +build !windows
+
+package gracefully
+
+import (
+	"errors"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestCommand_ExitCode(t *testing.T) {
+	sm := New()
+	sm.AddSignaler(NewContextSignal())
+	err := sm.Run(Command(exec.Command("sh", "-c", "exit 3"), CommandOptions{}))
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) {
+		t.Fatal("expected an ExitError, got: ", err)
+	}
+	if exitErr.Code != 3 || exitErr.Signal != nil {
+		t.Error("expected exit code 3 and no signal, got: ", exitErr.Code, exitErr.Signal)
+	}
+}
+
+func TestCommand_StopEscalatesToKill(t *testing.T) {
+	sm := New()
+	cs := NewContextSignal()
+	sm.AddSignaler(cs)
+	go func() {
+		time.Sleep(time.Second / 10)
+		cs.Stop()
+	}()
+	// Ignores SIGTERM, so it has to be killed
+	cmd := exec.Command("sh", "-c", "trap '' TERM; sleep 10")
+	err := sm.Run(Command(cmd, CommandOptions{StopTimeout: time.Second / 10}))
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) {
+		t.Fatal("expected an ExitError, got: ", err)
+	}
+	if exitErr.Signal != syscall.SIGKILL {
+		t.Error("expected process to be killed, got: ", exitErr)
+	}
+}
+
+func TestCommand_Stop(t *testing.T) {
+	sm := New()
+	cs := NewContextSignal()
+	sm.AddSignaler(cs)
+	go func() {
+		time.Sleep(time.Second / 10)
+		cs.Stop()
+	}()
+	started := time.Now()
+	err := sm.Run(Command(exec.Command("sleep", "10"), CommandOptions{}))
+	if err != nil {
+		t.Error(err)
+	}
+	if time.Since(started) > 5*time.Second {
+		t.Error("expected SIGTERM to stop the process")
+	}
+}
+
+func TestCommand_StopReportsFailure(t *testing.T) {
+	sm := New()
+	cs := NewContextSignal()
+	sm.AddSignaler(cs)
+	go func() {
+		time.Sleep(time.Second / 10)
+		cs.Stop()
+	}()
+	// Exits on SIGTERM, but not cleanly
+	cmd := exec.Command("sh", "-c", "trap 'exit 4' TERM; while true; do sleep 0.01; done")
+	err := sm.Run(Command(cmd, CommandOptions{}))
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) {
+		t.Fatal("expected an ExitError, got: ", err)
+	}
+	if exitErr.Code != 4 {
+		t.Error("expected exit code 4, got: ", exitErr.Code)
+	}
+}
+
+func TestCommand_StopAcceptsShellStatus(t *testing.T) {
+	sm := New()
+	cs := NewContextSignal()
+	sm.AddSignaler(cs)
+	go func() {
+		time.Sleep(time.Second / 10)
+		cs.Stop()
+	}()
+	// Exits the way a shell reports being terminated by SIGTERM
+	cmd := exec.Command("sh", "-c", "trap 'exit 143' TERM; while true; do sleep 0.01; done")
+	if err := sm.Run(Command(cmd, CommandOptions{})); err != nil {
+		t.Error("expected 128 plus the stop signal to be a clean stop, got: ", err)
+	}
+}
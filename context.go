@@ -26,3 +26,17 @@ func (c *ContextSignal) Restart() {
 		return GracefulRestart
 	}
 }
+
+// Pause triggers the system to pause
+func (c *ContextSignal) Pause() {
+	c.OnSignal <- func(manager *ServiceManager) GracefulAction {
+		return GracefulPause
+	}
+}
+
+// Resume triggers the system to resume after a pause
+func (c *ContextSignal) Resume() {
+	c.OnSignal <- func(manager *ServiceManager) GracefulAction {
+		return GracefulResume
+	}
+}
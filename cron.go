@@ -0,0 +1,188 @@
+package gracefully
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidCron is returned by ParseCron when an expression can't be understood
+var ErrInvalidCron = errors.New("gracefully: invalid cron expression")
+
+// cronMacros are the usual shorthands for common expressions
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// cronField describes the values one of the 5 fields can take
+type cronField struct {
+	name     string
+	min, max int
+	// names are what the values can also be called, such as JAN or MON
+	names map[string]int
+}
+
+var (
+	cronMinute = cronField{name: "minute", min: 0, max: 59}
+	cronHour   = cronField{name: "hour", min: 0, max: 23}
+	cronDom    = cronField{name: "day of month", min: 1, max: 31}
+	cronMonth  = cronField{name: "month", min: 1, max: 12, names: map[string]int{
+		"JAN": 1, "FEB": 2, "MAR": 3, "APR": 4, "MAY": 5, "JUN": 6, "JUL": 7, "AUG": 8, "SEP": 9, "OCT": 10, "NOV": 11, "DEC": 12,
+	}}
+	// 7 is also Sunday, folded into 0 once parsed
+	cronDow = cronField{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"SUN": 0, "MON": 1, "TUE": 2, "WED": 3, "THU": 4, "FRI": 5, "SAT": 6,
+	}}
+)
+
+// CronSchedule is when a standard 5-field cron expression fires: minute, hour, day of month, month and day of week.
+// Fields take *, values, names such as JAN or MON, ranges such as 1-5, lists such as 1,15 and steps such as */15 or 9-17/2.
+// Like cron, when both the day of month and the day of week are restricted, either one matching is enough.
+// Do not instantiate yourself, call: ParseCron
+type CronSchedule struct {
+	// each field is a bit set of the values it matches
+	minute, hour, dom, month, dow uint64
+	// domAny and dowAny are true if the field was *, which matters for how they combine
+	domAny, dowAny bool
+	// location is the time zone the expression is in
+	location *time.Location
+}
+
+// ParseCron parses a 5-field cron expression, or one of @yearly, @monthly, @weekly, @daily, @hourly, in the time zone
+// loc, or time.Local if nil
+func ParseCron(expr string, loc *time.Location) (*CronSchedule, error) {
+	if loc == nil {
+		loc = time.Local
+	}
+	expr = strings.TrimSpace(expr)
+	if macro, ok := cronMacros[strings.ToLower(expr)]; ok {
+		expr = macro
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("%w: expected 5 fields, got %d: %s", ErrInvalidCron, len(fields), expr)
+	}
+	c := &CronSchedule{location: loc}
+	var err error
+	if c.minute, _, err = cronMinute.parse(fields[0]); err != nil {
+		return nil, err
+	}
+	if c.hour, _, err = cronHour.parse(fields[1]); err != nil {
+		return nil, err
+	}
+	if c.dom, c.domAny, err = cronDom.parse(fields[2]); err != nil {
+		return nil, err
+	}
+	if c.month, _, err = cronMonth.parse(fields[3]); err != nil {
+		return nil, err
+	}
+	if c.dow, c.dowAny, err = cronDow.parse(fields[4]); err != nil {
+		return nil, err
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow = c.dow&^(1<<7) | 1
+	}
+	return c, nil
+}
+
+// parse parses the field into the bit set of the values it matches, star is true if it starts with *, which is how
+// cron tells whether days of the month and days of the week both have to match, such as */2, or either one
+func (f cronField) parse(field string) (bits uint64, star bool, err error) {
+	for _, part := range strings.Split(field, ",") {
+		lo, hi, step := f.min, f.max, 1
+		rangePart := part
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			rangePart = part[:i]
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, false, fmt.Errorf("%w: bad step in %s: %s", ErrInvalidCron, f.name, part)
+			}
+		}
+		switch {
+		case rangePart == "*":
+			star = strings.HasPrefix(field, "*")
+		case strings.Contains(rangePart, "-"):
+			bounds := strings.SplitN(rangePart, "-", 2)
+			if lo, err = f.value(bounds[0]); err != nil {
+				return 0, false, err
+			}
+			if hi, err = f.value(bounds[1]); err != nil {
+				return 0, false, err
+			}
+			if lo > hi {
+				return 0, false, fmt.Errorf("%w: backwards range in %s: %s", ErrInvalidCron, f.name, part)
+			}
+		default:
+			if lo, err = f.value(rangePart); err != nil {
+				return 0, false, err
+			}
+			// A single value with a step, such as 5/15, goes on to the end, like */15 starting at 5
+			if step == 1 {
+				hi = lo
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, star, nil
+}
+
+// value parses a single value or name of the field
+func (f cronField) value(s string) (int, error) {
+	if v, ok := f.names[strings.ToUpper(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("%w: bad %s: %s", ErrInvalidCron, f.name, s)
+	}
+	return v, nil
+}
+
+// Next is the first time the schedule fires strictly after t, the zero time if it never does, such as on February 30th
+func (c *CronSchedule) Next(t time.Time) time.Time {
+	t = t.In(c.location)
+	// Cron works to the minute
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, c.location).Add(time.Minute)
+	// Every combination repeats within a few years, leap years included
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, c.location)
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, c.location)
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			// Going by the wall clock, an hour that does not exist because of daylight saving time is skipped
+			next := time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, c.location)
+			if !next.After(t) {
+				// Falling back, the same wall clock hour happens twice
+				next = t.Add(time.Hour - time.Duration(t.Minute())*time.Minute)
+			}
+			t = next
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// dayMatches is true if the day of t matches, considering both the day of month and the day of week
+func (c *CronSchedule) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
@@ -0,0 +1,75 @@
+package gracefully
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron_Next(t *testing.T) {
+	utc := time.UTC
+	from := time.Date(2021, time.March, 10, 14, 22, 31, 0, utc) // a Wednesday
+	cases := []struct {
+		expr     string
+		expected time.Time
+	}{
+		{"* * * * *", time.Date(2021, time.March, 10, 14, 23, 0, 0, utc)},
+		{"*/15 * * * *", time.Date(2021, time.March, 10, 14, 30, 0, 0, utc)},
+		{"0 3 * * *", time.Date(2021, time.March, 11, 3, 0, 0, 0, utc)},
+		{"@daily", time.Date(2021, time.March, 11, 0, 0, 0, 0, utc)},
+		{"30 9-17/2 * * MON-FRI", time.Date(2021, time.March, 10, 15, 30, 0, 0, utc)},
+		{"0 0 * * 7", time.Date(2021, time.March, 14, 0, 0, 0, 0, utc)},
+		{"0 0 1 jan *", time.Date(2022, time.January, 1, 0, 0, 0, 0, utc)},
+		// Both days restricted, either one will do: the 15th, or a Friday first
+		{"0 12 15 * FRI", time.Date(2021, time.March, 12, 12, 0, 0, 0, utc)},
+		// A stepped star is still a star: odd days that are also Mondays
+		{"0 0 */2 * 1", time.Date(2021, time.March, 15, 0, 0, 0, 0, utc)},
+		{"0 0 29 2 *", time.Date(2024, time.February, 29, 0, 0, 0, 0, utc)},
+	}
+	for _, c := range cases {
+		cron, err := ParseCron(c.expr, utc)
+		if err != nil {
+			t.Error(c.expr, ": ", err)
+			continue
+		}
+		if next := cron.Next(from); !next.Equal(c.expected) {
+			t.Error(c.expr, ": expected ", c.expected, " got: ", next)
+		}
+	}
+}
+
+func TestParseCron_TimeZone(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("no time zone database: ", err)
+	}
+	cron, err := ParseCron("30 2 * * *", ny)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2:30 does not exist on the day clocks spring forward, the next one is the day after
+	next := cron.Next(time.Date(2021, time.March, 14, 0, 0, 0, 0, ny))
+	if expected := time.Date(2021, time.March, 15, 2, 30, 0, 0, ny); !next.Equal(expected) {
+		t.Error("expected ", expected, " got: ", next)
+	}
+	// Once in New York is 7:30 in UTC during the winter
+	next = cron.Next(time.Date(2021, time.January, 5, 12, 0, 0, 0, time.UTC))
+	if expected := time.Date(2021, time.January, 6, 7, 30, 0, 0, time.UTC); !next.Equal(expected) {
+		t.Error("expected ", expected, " got: ", next)
+	}
+}
+
+func TestParseCron_Invalid(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "0 0 0 * *", "5-1 * * * *", "*/0 * * * *", "0 0 * FOO *", "0 0 31 2 *x"} {
+		if _, err := ParseCron(expr, nil); err == nil {
+			t.Error("expected an error for: ", expr)
+		}
+	}
+	// Valid, but never happens
+	cron, err := ParseCron("0 0 31 2 *", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next := cron.Next(time.Now()); !next.IsZero() {
+		t.Error("expected February 31st to never happen, got: ", next)
+	}
+}
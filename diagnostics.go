@@ -0,0 +1,199 @@
+package gracefully
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// defaultProfileDuration is how long the CPU profile and the runtime trace run, if not configured
+const defaultProfileDuration = 5 * time.Second
+
+// ErrCaptureInProgress is returned by Diagnostics.Capture when the previous capture is not done yet
+var ErrCaptureInProgress = errors.New("gracefully: diagnostics capture already in progress")
+
+// DiagnosticsOptions configures a Diagnostics
+type DiagnosticsOptions struct {
+	// Signals are what trigger a capture. Defaults to SIGQUIT, which otherwise makes the go runtime dump the goroutines and exit
+	Signals []os.Signal
+	// Dir is where the timestamped directories with the captures are created. Defaults to os.TempDir()
+	Dir string
+	// Prefix starts the name of every timestamped directory. Defaults to the name of the executable
+	Prefix string
+	// ProfileDuration is how long the CPU profile and the runtime trace run, side by side. Defaults to 5 seconds, negative
+	// skips them
+	ProfileDuration time.Duration
+	// OnEvent, if set, is told where captures were written, and what went wrong
+	OnEvent EventHandler
+}
+
+// Diagnostics is a SignalSelecter that, on a signal, writes a goroutine dump, a heap profile, a CPU profile and a runtime
+// trace to a new timestamped directory, for looking into a service that misbehaves without restarting it.
+// It never tells the ServiceManager to do anything.
+// Do not instantiate yourself, call: NewDiagnostics
+type Diagnostics struct {
+	BaseSignaler
+	opts DiagnosticsOptions
+	// signalChan is where to put incoming signals from the OS
+	signalChan chan os.Signal
+	// capturing holds a value while a capture is in progress
+	capturing chan struct{}
+}
+
+// NewDiagnostics creates a Diagnostics capturing every time one of opts.Signals is received
+func NewDiagnostics(opts DiagnosticsOptions) *Diagnostics {
+	if len(opts.Signals) == 0 {
+		opts.Signals = []os.Signal{syscall.SIGQUIT}
+	}
+	if opts.Dir == "" {
+		opts.Dir = os.TempDir()
+	}
+	if opts.Prefix == "" {
+		opts.Prefix = filepath.Base(os.Args[0])
+	}
+	if opts.ProfileDuration == 0 {
+		opts.ProfileDuration = defaultProfileDuration
+	}
+	d := &Diagnostics{
+		BaseSignaler: NewBaseSignaler(),
+		opts:         opts,
+		// opting for size 1, signals arriving during a capture are dropped anyway
+		signalChan: make(chan os.Signal, 1),
+		capturing:  make(chan struct{}, 1),
+	}
+	signal.Notify(d.signalChan, opts.Signals...)
+	go d.run()
+	return d
+}
+
+// Cancel stops listening for the signals, and cuts short the capture in progress
+func (d *Diagnostics) Cancel() {
+	if d.cancel() {
+		signal.Stop(d.signalChan)
+	}
+}
+
+// run captures on every signal, until cancelled
+func (d *Diagnostics) run() {
+	for {
+		select {
+		case sig := <-d.signalChan:
+			// Capturing in the background, so that the signal is not dropped while a capture is going on, but reported
+			go func() {
+				dir, err := d.Capture()
+				switch {
+				case errors.Is(err, ErrCaptureInProgress):
+					d.emit(Event{Source: "diagnostics", Signal: sig, Message: "ignoring signal, a capture is in progress"})
+				case err != nil:
+					d.emit(Event{Source: "diagnostics", Signal: sig, Message: "capture to " + dir + " incomplete", Err: err})
+				default:
+					d.emit(Event{Source: "diagnostics", Signal: sig, Message: "captured to " + dir})
+				}
+			}()
+		case <-d.cancelled:
+			return
+		}
+	}
+}
+
+// Capture writes the diagnostics to a new timestamped directory, without waiting for a signal
+// @return the directory, and the first thing that went wrong, what could be captured is kept
+func (d *Diagnostics) Capture() (dir string, err error) {
+	select {
+	case d.capturing <- struct{}{}:
+		defer func() { <-d.capturing }()
+	default:
+		return "", ErrCaptureInProgress
+	}
+
+	dir = filepath.Join(d.opts.Dir, d.opts.Prefix+"-"+time.Now().UTC().Format("20060102T150405.000Z"))
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return dir, err
+	}
+	// Everything is attempted, the first error is the one reported
+	keep := func(e error) {
+		if err == nil {
+			err = e
+		}
+	}
+	keep(writeFile(filepath.Join(dir, "goroutines.txt"), func(f *os.File) error {
+		// Same format as the dump of an unhandled SIGQUIT
+		return pprof.Lookup("goroutine").WriteTo(f, 2)
+	}))
+	keep(writeFile(filepath.Join(dir, "heap.pprof"), func(f *os.File) error {
+		// Up to date with what is live now, rather than as of the last collection
+		runtime.GC()
+		return pprof.Lookup("heap").WriteTo(f, 0)
+	}))
+	if d.opts.ProfileDuration < 0 {
+		return dir, err
+	}
+
+	var wg sync.WaitGroup
+	var cpuErr, traceErr error
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		cpuErr = writeFile(filepath.Join(dir, "cpu.pprof"), func(f *os.File) error {
+			if err := pprof.StartCPUProfile(f); err != nil {
+				return err
+			}
+			d.sleep(d.opts.ProfileDuration)
+			pprof.StopCPUProfile()
+			return nil
+		})
+	}()
+	go func() {
+		defer wg.Done()
+		traceErr = writeFile(filepath.Join(dir, "trace.out"), func(f *os.File) error {
+			if err := trace.Start(f); err != nil {
+				return err
+			}
+			d.sleep(d.opts.ProfileDuration)
+			trace.Stop()
+			return nil
+		})
+	}()
+	wg.Wait()
+	keep(cpuErr)
+	keep(traceErr)
+	return dir, err
+}
+
+// sleep waits for duration, or until cancelled
+func (d *Diagnostics) sleep(duration time.Duration) {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-d.cancelled:
+	}
+}
+
+// emit reports the event, if anyone is listening
+func (d *Diagnostics) emit(e Event) {
+	if d.opts.OnEvent != nil {
+		d.opts.OnEvent(e)
+	}
+}
+
+// writeFile creates the file at path and fills it with write
+func writeFile(path string, write func(f *os.File) error) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if err = write(f); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return f.Close()
+}
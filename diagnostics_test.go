@@ -0,0 +1,78 @@
+//go:build !windows
+// +build !windows
+
+package gracefully
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestDiagnostics_Capture(t *testing.T) {
+	d := NewDiagnostics(DiagnosticsOptions{Signals: []os.Signal{syscall.SIGUSR2}, Dir: t.TempDir(), Prefix: "test", ProfileDuration: 50 * time.Millisecond})
+	defer d.Cancel()
+	dir, err := d.Capture()
+	if err != nil {
+		t.Fatal("expected capture to succeed, got: ", err)
+	}
+	if !strings.HasPrefix(filepath.Base(dir), "test-") {
+		t.Error("expected timestamped directory, got: ", dir)
+	}
+	for _, name := range []string{"goroutines.txt", "heap.pprof", "cpu.pprof", "trace.out"} {
+		if info, err := os.Stat(filepath.Join(dir, name)); err != nil || info.Size() == 0 {
+			t.Error("expected non-empty ", name, ", got: ", err)
+		}
+	}
+	dump, _ := os.ReadFile(filepath.Join(dir, "goroutines.txt"))
+	if !bytes.Contains(dump, []byte("TestDiagnostics_Capture")) {
+		t.Error("expected the goroutine dump to have this test in it")
+	}
+}
+
+func TestDiagnostics_SignalWithoutAction(t *testing.T) {
+	events := make(chan Event, 10)
+	d := NewDiagnostics(DiagnosticsOptions{Dir: t.TempDir(), ProfileDuration: -1, OnEvent: func(e Event) { events <- e }})
+	defer d.Cancel()
+	// SIGQUIT would kill the test binary, if it was not handled
+	if err := syscall.Kill(os.Getpid(), syscall.SIGQUIT); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case e := <-events:
+		if e.Err != nil || e.Signal != syscall.SIGQUIT || !strings.HasPrefix(e.Message, "captured to ") {
+			t.Error("expected a capture, got: ", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a capture")
+	}
+	expectNoAction(t, d, 20*time.Millisecond)
+}
+
+func TestDiagnostics_OneAtATime(t *testing.T) {
+	d := NewDiagnostics(DiagnosticsOptions{Signals: []os.Signal{syscall.SIGUSR2}, Dir: t.TempDir(), ProfileDuration: time.Minute})
+	done := make(chan error, 1)
+	go func() {
+		_, err := d.Capture()
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if _, err := d.Capture(); !errors.Is(err, ErrCaptureInProgress) {
+		t.Error("expected ErrCaptureInProgress, got: ", err)
+	}
+	// Cancel cuts the profile short
+	d.Cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error("expected the first capture to succeed, got: ", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Cancel to cut the capture short")
+	}
+}
@@ -0,0 +1,242 @@
+//go:build !windows
+// +build !windows
+
+package gracefully
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// maxFIFOCommand is the longest command FIFOControl reads, longer lines are ignored
+const maxFIFOCommand = 4096
+
+// ErrNotFIFO is returned by NewFIFOControl when something other than a named pipe is in the way
+var ErrNotFIFO = errors.New("gracefully: not a named pipe")
+
+// FIFOControlOptions configures a FIFOControl
+type FIFOControlOptions struct {
+	// Commands maps the lines written to the pipe, without surrounding spaces, to what the ServiceManager should do.
+	// Defaults to StdinCommands, more can be added with Register
+	Commands map[string]GracefulAction
+	// Mode is the permissions of the named pipe, if it's created. Defaults to 0600
+	Mode os.FileMode
+	// OnEvent, if set, is told about unknown commands and read errors
+	OnEvent EventHandler
+}
+
+// FIFOControl is a SignalSelecter that reads commands, one per line, from a named pipe, for environments where sockets are
+// awkward: echo restart > /run/myservice.ctl
+// Every writer is read until it closes the pipe, the pipe stays open for the next one so that nothing it writes is lost.
+// The pipe is never opened in a way that blocks, so nothing waits on a writer showing up. The pipe is removed on Cancel,
+// if it was created by us.
+// Do not instantiate yourself, call: NewFIFOControl
+type FIFOControl struct {
+	BaseSignaler
+	path    string
+	onEvent EventHandler
+	// created is true if we made the named pipe, and have to remove it
+	created bool
+	// mu protects commands and current, and is held while cancelling, so that a pipe opened in the meantime is not missed
+	mu       sync.Mutex
+	commands map[string]GracefulAction
+	// current is the pipe being read
+	current *os.File
+}
+
+// NewFIFOControl creates the named pipe at path, unless there already is one, and starts reading commands from it
+func NewFIFOControl(path string, opts FIFOControlOptions) (*FIFOControl, error) {
+	if opts.Commands == nil {
+		opts.Commands = StdinCommands()
+	}
+	if opts.Mode == 0 {
+		opts.Mode = 0600
+	}
+	f := &FIFOControl{
+		BaseSignaler: NewBaseSignaler(),
+		path:         path,
+		onEvent:      opts.OnEvent,
+		commands:     make(map[string]GracefulAction, len(opts.Commands)),
+	}
+	for command, action := range opts.Commands {
+		f.commands[command] = action
+	}
+
+	err := syscall.Mkfifo(path, uint32(opts.Mode.Perm()))
+	switch {
+	case err == nil:
+		f.created = true
+	case errors.Is(err, syscall.EEXIST):
+		info, statErr := os.Stat(path)
+		if statErr != nil {
+			return nil, statErr
+		}
+		if info.Mode()&os.ModeNamedPipe == 0 {
+			return nil, fmt.Errorf("%w: %s", ErrNotFIFO, path)
+		}
+	default:
+		return nil, &os.PathError{Op: "mkfifo", Path: path, Err: err}
+	}
+
+	go f.run()
+	return f, nil
+}
+
+// Register adds a command, or changes what an existing one does
+func (f *FIFOControl) Register(command string, action GracefulAction) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.commands[command] = action
+}
+
+// Cancel stops reading, and removes the named pipe if we created it
+func (f *FIFOControl) Cancel() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.cancel() {
+		return
+	}
+	if f.current != nil {
+		// Interrupts the read in progress
+		_ = f.current.SetReadDeadline(time.Unix(1, 0))
+	}
+	if f.created {
+		_ = os.Remove(f.path)
+	}
+}
+
+// run opens the pipe and reads one writer after another from it, until cancelled
+func (f *FIFOControl) run() {
+	for {
+		// Non-blocking: opening for reading does not wait for a writer, and the runtime poller takes care of the reads
+		file, err := os.OpenFile(f.path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+		f.mu.Lock()
+		select {
+		case <-f.cancelled:
+			f.mu.Unlock()
+			if file != nil {
+				_ = file.Close()
+			}
+			return
+		default:
+		}
+		f.current = file
+		f.mu.Unlock()
+		if err != nil {
+			f.emit(Event{Source: "fifo", Message: "unable to open " + f.path, Err: err})
+			// Try again later, rather than give up on being controlled
+			select {
+			case <-time.After(time.Second):
+				continue
+			case <-f.cancelled:
+				return
+			}
+		}
+
+		err = f.readWriters(file)
+		_ = file.Close()
+		f.mu.Lock()
+		f.current = nil
+		f.mu.Unlock()
+		if err != nil {
+			select {
+			case <-f.cancelled:
+				return
+			default:
+			}
+			f.emit(Event{Source: "fifo", Message: "unable to read " + f.path, Err: err})
+		}
+	}
+}
+
+// readWriters reads the commands of one writer after another. Closing the pipe between writers would drop whatever a
+// writer that opened it in the meantime wrote
+// @return an error if the pipe could not be read, or if cancelled
+func (f *FIFOControl) readWriters(file *os.File) error {
+	conn, err := file.SyscallConn()
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, maxFIFOCommand)
+	pending := make([]byte, 0, maxFIFOCommand)
+	// tooLong is true while skipping the rest of a line that did not fit
+	tooLong := false
+	// wrote is true once a writer wrote something
+	wrote := false
+	for {
+		var n int
+		var readErr error
+		err := conn.Read(func(fd uintptr) bool {
+			n, readErr = syscall.Read(int(fd), buf)
+			if readErr == syscall.EAGAIN {
+				return false
+			}
+			// Until a writer wrote something, there is no writer to see the end of. Wait for one
+			return n != 0 || readErr != nil || wrote
+		})
+		if err != nil {
+			return err
+		}
+		if readErr != nil {
+			return readErr
+		}
+		wrote = true
+		if n == 0 {
+			// The writer is done, whatever is left without a newline is its last command. Wait for the next one
+			if !tooLong {
+				f.handle(pending)
+			}
+			tooLong = false
+			wrote = false
+			pending = pending[:0]
+			continue
+		}
+		for data := buf[:n]; len(data) > 0; {
+			i := bytes.IndexByte(data, '\n')
+			if i < 0 {
+				if len(pending)+len(data) > maxFIFOCommand {
+					tooLong = true
+					pending = pending[:0]
+				} else if !tooLong {
+					pending = append(pending, data...)
+				}
+				break
+			}
+			if !tooLong {
+				f.handle(append(pending, data[:i]...))
+			}
+			tooLong = false
+			pending = pending[:0]
+			data = data[i+1:]
+		}
+	}
+}
+
+// handle tells the ServiceManager what to do about a command
+func (f *FIFOControl) handle(line []byte) {
+	command := strings.TrimSpace(string(line))
+	if command == "" {
+		return
+	}
+	f.mu.Lock()
+	action, ok := f.commands[command]
+	f.mu.Unlock()
+	if !ok {
+		f.emit(Event{Source: "fifo", Message: "unknown command: " + command})
+		return
+	}
+	f.sendAction(action)
+}
+
+// emit reports the event, if anyone is listening
+func (f *FIFOControl) emit(e Event) {
+	if f.onEvent != nil {
+		f.onEvent(e)
+	}
+}
@@ -0,0 +1,89 @@
+//go:build !windows
+// +build !windows
+
+package gracefully
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// writeFIFO writes to the named pipe like echo would, as a writer of its own
+func writeFIFO(t *testing.T, path, data string) {
+	t.Helper()
+	w, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = w.WriteString(data)
+	_ = w.Close()
+}
+
+func TestFIFOControl_Commands(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ctl")
+	var mu sync.Mutex
+	events := make([]Event, 0)
+	f, err := NewFIFOControl(path, FIFOControlOptions{OnEvent: func(e Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, e)
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Register("maintenance", GracefulPause)
+
+	writeFIFO(t, path, "restart\n")
+	expectAction(t, f, GracefulRestart)
+	// One writer after the other, the last line does not need a newline
+	writeFIFO(t, path, "bogus\nmaintenance")
+	expectAction(t, f, GracefulPause)
+	writeFIFO(t, path, "stop\n")
+	expectAction(t, f, GracefulStop)
+
+	f.Cancel()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("expected the named pipe to be removed, got: ", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) != 1 || events[0].Message != "unknown command: bogus" {
+		t.Error("expected the unknown command to be reported, got: ", events)
+	}
+}
+
+func TestFIFOControl_NoWriterDoesNotBlock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ctl")
+	f, err := NewFIFOControl(path, FIFOControlOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm := New()
+	cs := NewContextSignal()
+	sm.AddSignaler(f)
+	sm.AddSignaler(cs)
+	err = sm.Run(func(ctx context.Context) error {
+		cs.Stop()
+		<-ctx.Done()
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("expected the named pipe to be removed, got: ", err)
+	}
+}
+
+func TestFIFOControl_NotAFIFO(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ctl")
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFIFOControl(path, FIFOControlOptions{}); err == nil {
+		t.Error("expected an error for a regular file")
+	}
+}
@@ -0,0 +1,220 @@
+package gracefully
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultWatchDebounce is how long changes must settle before FileWatch acts, if not configured
+	defaultWatchDebounce = 500 * time.Millisecond
+	// defaultWatchPollInterval is how often FileWatch looks for changes when polling, if not configured
+	defaultWatchPollInterval = 2 * time.Second
+	// configMapData is the symlink kubernetes swaps to update all the files of a mounted ConfigMap or Secret at once
+	configMapData = "..data"
+)
+
+// FileWatchOptions configures a FileWatch
+type FileWatchOptions struct {
+	// Action is what the ServiceManager should do once files changed. Left unset, it's GracefulRestart, the zero value
+	Action GracefulAction
+	// Debounce is how long changes must settle before acting, so that an editor saving a file, which writes, renames and
+	// chmods, only triggers Action once. Defaults to 500 milliseconds
+	Debounce time.Duration
+	// PollInterval is how often files are checked when inotify is unavailable, and how often watches that could not be
+	// set up, such as for a directory that does not exist yet, are retried. Defaults to 2 seconds
+	PollInterval time.Duration
+	// OnEvent, if set, is told when inotify is unavailable and polling is used instead
+	OnEvent EventHandler
+}
+
+// watcher looks for changes, it notifies its changes channel without blocking
+type watcher interface {
+	close()
+}
+
+// FileWatch is a SignalSelecter that tells the ServiceManager what to do when files or directories change, such as
+// restarting to load a new configuration. It uses inotify on linux, and polls modification times otherwise.
+// Kubernetes updates a mounted ConfigMap by swapping its ..data symlink, which is also seen as a change of its files.
+// Do not instantiate yourself, call: NewFileWatch
+type FileWatch struct {
+	BaseSignaler
+	opts FileWatchOptions
+}
+
+// NewFileWatch creates a FileWatch watching paths, files or directories, which do not need to exist yet
+func NewFileWatch(paths []string, opts FileWatchOptions) *FileWatch {
+	if opts.Debounce <= 0 {
+		opts.Debounce = defaultWatchDebounce
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = defaultWatchPollInterval
+	}
+	f := &FileWatch{
+		BaseSignaler: NewBaseSignaler(),
+		opts:         opts,
+	}
+	changes := make(chan struct{}, 1)
+	var w watcher
+	if iw, err := newInotifyWatcher(paths, opts.PollInterval, changes); err == nil {
+		w = iw
+	} else {
+		if opts.OnEvent != nil {
+			opts.OnEvent(Event{Source: "filewatch", Message: "inotify unavailable, polling instead", Err: err})
+		}
+		w = newPollWatcher(paths, opts.PollInterval, changes)
+	}
+	go f.run(w, changes)
+	return f
+}
+
+// Cancel stops watching
+func (f *FileWatch) Cancel() {
+	f.cancel()
+}
+
+// run acts once changes settled, until cancelled
+func (f *FileWatch) run(w watcher, changes <-chan struct{}) {
+	defer w.close()
+	debounce := time.NewTimer(f.opts.Debounce)
+	if !debounce.Stop() {
+		<-debounce.C
+	}
+	for {
+		select {
+		case <-changes:
+			// Wait for it to settle
+			if !debounce.Stop() {
+				select {
+				case <-debounce.C:
+				default:
+				}
+			}
+			debounce.Reset(f.opts.Debounce)
+		case <-debounce.C:
+			if !f.sendAction(f.opts.Action) {
+				return
+			}
+		case <-f.cancelled:
+			return
+		}
+	}
+}
+
+// notifyChange tells whoever is waiting on changes, without blocking, one pending notification is enough
+func notifyChange(changes chan<- struct{}) {
+	select {
+	case changes <- struct{}{}:
+	default:
+	}
+}
+
+// watchTargets are the directories to watch for paths, and the names in them that matter, nil for all of them.
+// Files are watched through their directory, which sees them being replaced, and so are their symlink targets
+func watchTargets(paths []string) map[string]map[string]bool {
+	targets := make(map[string]map[string]bool)
+	add := func(dir, name string) {
+		names, ok := targets[dir]
+		switch {
+		case ok && names == nil:
+			// Already watching everything
+		case name == "":
+			targets[dir] = nil
+		default:
+			if names == nil {
+				names = make(map[string]bool)
+				targets[dir] = names
+			}
+			names[name] = true
+			names[configMapData] = true
+		}
+	}
+	for _, path := range paths {
+		path = filepath.Clean(path)
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			add(path, "")
+		} else {
+			add(filepath.Dir(path), filepath.Base(path))
+		}
+		if resolved, err := filepath.EvalSymlinks(path); err == nil && resolved != path {
+			if info, err := os.Stat(resolved); err == nil && info.IsDir() {
+				add(resolved, "")
+			} else {
+				add(filepath.Dir(resolved), filepath.Base(resolved))
+			}
+		}
+	}
+	return targets
+}
+
+// pollWatcher looks for changes by comparing what paths look like, every so often
+type pollWatcher struct {
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// newPollWatcher starts polling paths
+func newPollWatcher(paths []string, interval time.Duration, changes chan<- struct{}) *pollWatcher {
+	p := &pollWatcher{done: make(chan struct{})}
+	// What they look like now, before returning, is what changes are compared to
+	last := fingerprint(paths)
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if current := fingerprint(paths); current != last {
+					last = current
+					notifyChange(changes)
+				}
+			case <-p.done:
+				return
+			}
+		}
+	}()
+	return p
+}
+
+// close stops polling
+func (p *pollWatcher) close() {
+	close(p.done)
+	p.wg.Wait()
+}
+
+// fingerprint describes paths, following symlinks, so that any change to them changes it. Directories are described
+// by their entries, which os.ReadDir sorts
+func fingerprint(paths []string) string {
+	var b strings.Builder
+	describe := func(path string, info os.FileInfo) {
+		fmt.Fprintf(&b, "%s %d %d %v\n", path, info.ModTime().UnixNano(), info.Size(), info.Mode())
+	}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			fmt.Fprintf(&b, "%s missing\n", path)
+			continue
+		}
+		describe(path, info)
+		if !info.IsDir() {
+			continue
+		}
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			entryPath := filepath.Join(path, entry.Name())
+			if info, err := os.Stat(entryPath); err == nil {
+				describe(entryPath, info)
+			}
+		}
+	}
+	return b.String()
+}
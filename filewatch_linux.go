@@ -0,0 +1,164 @@
+package gracefully
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// inotifyMask is everything that changes a file or a directory's entries
+const inotifyMask = syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE | syscall.IN_ATTRIB | syscall.IN_CREATE | syscall.IN_DELETE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+
+// inotifyWatcher looks for changes with inotify
+type inotifyWatcher struct {
+	file  *os.File
+	paths []string
+	// retry is how often watches that could not be set up are tried again
+	retry time.Duration
+	// watches are the names that matter in each watched directory, keyed by watch descriptor, nil names for all of them
+	watches map[int32]map[string]bool
+	// missing is true if some watches could not be set up
+	missing bool
+	wg      sync.WaitGroup
+}
+
+// newInotifyWatcher starts watching paths with inotify
+func newInotifyWatcher(paths []string, retry time.Duration, changes chan<- struct{}) (*inotifyWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	w := &inotifyWatcher{
+		// Non-blocking, so the runtime poller takes care of it and close interrupts a read
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		paths:   paths,
+		retry:   retry,
+		watches: make(map[int32]map[string]bool),
+	}
+	w.rewatch()
+	w.wg.Add(1)
+	go w.read(changes)
+	return w, nil
+}
+
+// close stops watching
+func (w *inotifyWatcher) close() {
+	_ = w.file.Close()
+	w.wg.Wait()
+}
+
+// read reads events until closed
+func (w *inotifyWatcher) read(changes chan<- struct{}) {
+	defer w.wg.Done()
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		if w.missing {
+			_ = w.file.SetReadDeadline(time.Now().Add(w.retry))
+		} else {
+			_ = w.file.SetReadDeadline(time.Time{})
+		}
+		n, err := w.file.Read(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			// Something we could not watch may be there now, if so, it changed
+			w.rewatch()
+			if !w.missing {
+				notifyChange(changes)
+			}
+			continue
+		}
+		if err != nil {
+			// Closed
+			return
+		}
+		if w.changed(buf[:n]) {
+			// Files and symlinks may have been replaced, watch what's there now
+			w.rewatch()
+			notifyChange(changes)
+		}
+	}
+}
+
+// changed is true if any of the events in buf matter
+func (w *inotifyWatcher) changed(buf []byte) bool {
+	changed := false
+	for offset := 0; offset+syscall.SizeofInotifyEvent <= len(buf); {
+		event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+		nameBytes := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(event.Len)]
+		offset += syscall.SizeofInotifyEvent + int(event.Len)
+
+		names, ok := w.watches[event.Wd]
+		switch {
+		case event.Mask&syscall.IN_Q_OVERFLOW != 0:
+			// Events were lost, anything may have changed. It comes with a watch descriptor of -1, check it first
+			changed = true
+		case !ok:
+			// Left over from a watch we replaced
+		case event.Mask&(syscall.IN_IGNORED|syscall.IN_DELETE_SELF|syscall.IN_MOVE_SELF) != 0:
+			// The directory itself is gone
+			changed = true
+		case names == nil:
+			changed = true
+		default:
+			name := string(nameBytes)
+			for i, c := range nameBytes {
+				if c == 0 {
+					name = string(nameBytes[:i])
+					break
+				}
+			}
+			changed = changed || names[name]
+		}
+	}
+	return changed
+}
+
+// rewatch watches what paths point to now, and stops watching what they no longer do
+func (w *inotifyWatcher) rewatch() {
+	conn, err := w.file.SyscallConn()
+	if err != nil {
+		return
+	}
+	// Within Control, so that the descriptor can't be closed and reused under us
+	_ = conn.Control(func(fd uintptr) {
+		watches := make(map[int32]map[string]bool)
+		missing := false
+		for dir, names := range watchTargets(w.paths) {
+			wd, err := syscall.InotifyAddWatch(int(fd), dir, inotifyMask)
+			if err != nil {
+				missing = true
+				continue
+			}
+			// Two paths may lead to the same directory, the watch is the same
+			if existing, ok := watches[int32(wd)]; ok {
+				names = mergeNames(existing, names)
+			}
+			watches[int32(wd)] = names
+		}
+		for wd := range w.watches {
+			if _, ok := watches[wd]; !ok {
+				_, _ = syscall.InotifyRmWatch(int(fd), uint32(wd))
+			}
+		}
+		w.watches = watches
+		w.missing = missing
+	})
+}
+
+// mergeNames gets the names that matter to either, nil for all of them
+func mergeNames(a, b map[string]bool) map[string]bool {
+	if a == nil || b == nil {
+		return nil
+	}
+	merged := make(map[string]bool, len(a)+len(b))
+	for name := range a {
+		merged[name] = true
+	}
+	for name := range b {
+		merged[name] = true
+	}
+	return merged
+}
@@ -0,0 +1,28 @@
+package gracefully
+
+import (
+	"syscall"
+	"testing"
+	"unsafe"
+)
+
+// inotifyEvents encodes events the way the kernel hands them out, names are padded with NULs
+func inotifyEvents(events ...syscall.InotifyEvent) []byte {
+	buf := make([]byte, 0, len(events)*syscall.SizeofInotifyEvent)
+	for _, event := range events {
+		buf = append(buf, (*[syscall.SizeofInotifyEvent]byte)(unsafe.Pointer(&event))[:]...)
+		buf = append(buf, make([]byte, event.Len)...)
+	}
+	return buf
+}
+
+func TestInotifyWatcher_Overflow(t *testing.T) {
+	w := &inotifyWatcher{watches: map[int32]map[string]bool{1: {"app.conf": true}}}
+	if w.changed(inotifyEvents(syscall.InotifyEvent{Wd: 1, Mask: syscall.IN_MODIFY, Len: 16})) {
+		t.Error("expected a change to a file we don't watch not to matter")
+	}
+	// The kernel could not keep up, and says so without a watch descriptor
+	if !w.changed(inotifyEvents(syscall.InotifyEvent{Wd: -1, Mask: syscall.IN_Q_OVERFLOW})) {
+		t.Error("expected an overflow to be handled as a change")
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+// +build !linux
+
+package gracefully
+
+import (
+	"errors"
+	"time"
+)
+
+// inotifyWatcher is only available on linux
+type inotifyWatcher struct{}
+
+// newInotifyWatcher always fails outside of linux, FileWatch polls instead
+func newInotifyWatcher([]string, time.Duration, chan<- struct{}) (*inotifyWatcher, error) {
+	return nil, errors.New("gracefully: inotify is only supported on linux")
+}
+
+func (w *inotifyWatcher) close() {}
@@ -0,0 +1,111 @@
+package gracefully
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileWatch_DebouncesEditorSave(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.yaml")
+	if err := os.WriteFile(path, []byte("a: 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fw := NewFileWatch([]string{path}, FileWatchOptions{Debounce: 50 * time.Millisecond, PollInterval: 10 * time.Millisecond})
+	defer fw.Cancel()
+
+	// What editors do: write a temporary file, rename it over the original, chmod it
+	tmp := filepath.Join(dir, ".app.yaml.swp")
+	if err := os.WriteFile(tmp, []byte("a: 2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, 0644); err != nil {
+		t.Fatal(err)
+	}
+	expectAction(t, fw, GracefulRestart)
+	expectNoAction(t, fw, 100*time.Millisecond)
+
+	// Unrelated files in the same directory do not matter
+	if err := os.WriteFile(filepath.Join(dir, "other"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expectNoAction(t, fw, 100*time.Millisecond)
+}
+
+func TestFileWatch_ConfigMapSwap(t *testing.T) {
+	// Laid out like kubernetes mounts a ConfigMap
+	dir := t.TempDir()
+	mustWrite := func(version, content string) {
+		if err := os.Mkdir(filepath.Join(dir, version), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, version, "app.yaml"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mustWrite("..2020_01", "a: 1\n")
+	if err := os.Symlink("..2020_01", filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "app.yaml")
+	if err := os.Symlink(filepath.Join("..data", "app.yaml"), path); err != nil {
+		t.Fatal(err)
+	}
+	fw := NewFileWatch([]string{path}, FileWatchOptions{Action: GracefulStop, Debounce: 20 * time.Millisecond})
+	defer fw.Cancel()
+
+	// The update: a new version, then the ..data symlink swapped atomically, then the old version removed
+	for i, version := range []string{"..2020_02", "..2020_03"} {
+		mustWrite(version, "a: 2\n")
+		tmp := filepath.Join(dir, "..data_tmp")
+		if err := os.Symlink(version, tmp); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmp, filepath.Join(dir, "..data")); err != nil {
+			t.Fatal(err)
+		}
+		old := "..2020_01"
+		if i > 0 {
+			old = "..2020_02"
+		}
+		if err := os.RemoveAll(filepath.Join(dir, old)); err != nil {
+			t.Fatal(err)
+		}
+		expectAction(t, fw, GracefulStop)
+	}
+}
+
+func TestFileWatch_NotThereYet(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "conf.d")
+	fw := NewFileWatch([]string{dir}, FileWatchOptions{Debounce: 10 * time.Millisecond, PollInterval: 10 * time.Millisecond})
+	defer fw.Cancel()
+	expectNoAction(t, fw, 50*time.Millisecond)
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	expectAction(t, fw, GracefulRestart)
+	if err := os.WriteFile(filepath.Join(dir, "extra.conf"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expectAction(t, fw, GracefulRestart)
+}
+
+func TestFileWatch_Polling(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.yaml")
+	changes := make(chan struct{}, 1)
+	w := newPollWatcher([]string{path}, 5*time.Millisecond, changes)
+	defer w.close()
+	if err := os.WriteFile(path, []byte("a: 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-changes:
+	case <-time.After(time.Second):
+		t.Error("expected the new file to be seen")
+	}
+}
@@ -0,0 +1,128 @@
+//go:build !windows
+// +build !windows
+
+package gracefully
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// Forwarder relays the operating system signals this process receives to registered child processes, such as those
+// started by Command. Each child chooses which signals it wants, for example SIGUSR1 for a sidecar that rotates its logs.
+// Failures to deliver a signal are reported to the EventHandler, a child that no longer exists is unregistered.
+//
+// Do not instantiate yourself, call: NewForwarder
+type Forwarder struct {
+	onEvent EventHandler
+	// mu protects targets
+	mu sync.Mutex
+	// targets are the registered children, keyed by pid
+	targets map[int]forwardTarget
+}
+
+// forwardTarget is a child registered with a Forwarder
+type forwardTarget struct {
+	// group is true if the signal should go to the whole process group led by the pid
+	group bool
+	// signals are the signals this child wants
+	signals map[os.Signal]bool
+}
+
+// NewForwarder creates a Forwarder with no children. onEvent is called when a signal could not be delivered, it may be nil
+func NewForwarder(onEvent EventHandler) *Forwarder {
+	return &Forwarder{
+		onEvent: onEvent,
+		targets: make(map[int]forwardTarget),
+	}
+}
+
+// Register starts relaying sigs to the process pid. If group is true, the signals are sent to the process group pid leads instead.
+// Registering the same pid again replaces its signals
+func (f *Forwarder) Register(pid int, group bool, sigs ...os.Signal) {
+	target := forwardTarget{
+		group:   group,
+		signals: make(map[os.Signal]bool, len(sigs)),
+	}
+	for _, sig := range sigs {
+		target.signals[sig] = true
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.targets[pid] = target
+}
+
+// Unregister stops relaying signals to pid
+func (f *Forwarder) Unregister(pid int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.targets, pid)
+}
+
+// Forward sends sig to every registered child that wants it
+func (f *Forwarder) Forward(sig os.Signal) {
+	f.send(sig, false)
+}
+
+// send sends sig to the registered children that want it, or all of them
+func (f *Forwarder) send(sig os.Signal, all bool) {
+	sysSig, ok := sig.(syscall.Signal)
+	if !ok {
+		f.emit(Event{Source: "forwarder", Signal: sig, Message: "only syscall.Signal can be forwarded", Err: syscall.EINVAL})
+		return
+	}
+	// Reported once unlocked, so that OnEvent can register or remove children
+	var failed []Event
+	f.mu.Lock()
+	for pid, target := range f.targets {
+		if !all && !target.signals[sig] {
+			continue
+		}
+		dest := pid
+		if target.group {
+			dest = -pid
+		}
+		err := syscall.Kill(dest, sysSig)
+		if err == nil {
+			continue
+		}
+		if errors.Is(err, syscall.ESRCH) {
+			// It's gone, no point in trying again
+			delete(f.targets, pid)
+		}
+		failed = append(failed, Event{Source: "forwarder", Signal: sig, Message: fmt.Sprintf("unable to forward signal to pid %d", pid), Err: err})
+	}
+	f.mu.Unlock()
+	for _, e := range failed {
+		f.emit(e)
+	}
+}
+
+// Broadcast sends sig to every registered child, whether they asked for it or not, such as SIGKILL when they took too long to stop
+func (f *Forwarder) Broadcast(sig os.Signal) {
+	f.send(sig, true)
+}
+
+// emit reports the event, if anyone is listening
+func (f *Forwarder) emit(e Event) {
+	if f.onEvent != nil {
+		f.onEvent(e)
+	}
+}
+
+// NewForwardingSignals creates a new Signals SignalSelecter like NewSignals, that also relays the forwarded signals to the
+// children registered with forwarder. Forwarded signals are only mapped to an action if they are also in signalsAndActions
+func NewForwardingSignals(signalsAndActions map[os.Signal]GracefulAction, forwarder *Forwarder, forwarded ...os.Signal) *Signals {
+	return newSignals(actionHandlers(signalsAndActions), nil, forwarder.Forward, forwarded...)
+}
+
+// DefaultForwardingSignals creates a new Signals SignalSelecter pre-configured like DefaultSignals, that also relays the
+// forwarded signals to the children registered with forwarder
+func DefaultForwardingSignals(forwarder *Forwarder, forwarded ...os.Signal) *Signals {
+	s := NewForwardingSignals(defaultSignals, forwarder, forwarded...)
+	s.SetEscalation(defaultEscalation)
+	return s
+}
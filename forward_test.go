@@ -0,0 +1,90 @@
+//go:build !windows
+// +build !windows
+
+package gracefully
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestForwardingSignals_RelaysToCommand(t *testing.T) {
+	forwarder := NewForwarder(nil)
+	sigs := NewForwardingSignals(map[os.Signal]GracefulAction{syscall.SIGTERM: GracefulStop}, forwarder, syscall.SIGUSR1)
+	sm := New()
+	sm.AddSignaler(sigs)
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("sh", "-c", "trap 'exit 7' USR1; echo ready; while true; do sleep 0.01; done")
+	cmd.Stdout = w
+	go func() {
+		// Once the trap is set, pretend the OS sent us SIGUSR1
+		_, _ = bufio.NewReader(r).ReadString('\n')
+		// Command registers the child once started, the child may well be ready before that
+		for !forwarderHasTargets(forwarder) {
+			time.Sleep(time.Millisecond)
+		}
+		sigs.signalChan <- syscall.SIGUSR1
+	}()
+	err = sm.Run(Command(cmd, CommandOptions{Forwarder: forwarder, ForwardSignals: []os.Signal{syscall.SIGUSR1}}))
+	_ = w.Close()
+	_ = r.Close()
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) || exitErr.Code != 7 {
+		t.Error("expected the child to exit with code 7 from the forwarded signal, got: ", err)
+	}
+}
+
+// forwarderHasTargets is true once a child is registered with f
+func forwarderHasTargets(f *Forwarder) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.targets) > 0
+}
+
+func TestForwarder_ReportsGoneChild(t *testing.T) {
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	events := make([]Event, 0, 1)
+	forwarder := NewForwarder(func(e Event) {
+		events = append(events, e)
+	})
+	forwarder.Register(cmd.Process.Pid, false, syscall.SIGUSR1)
+	forwarder.Forward(syscall.SIGUSR1)
+	forwarder.Forward(syscall.SIGUSR1)
+	if len(events) != 1 || !errors.Is(events[0].Err, syscall.ESRCH) {
+		t.Error("expected a single ESRCH event, got: ", events)
+	}
+}
+
+func TestForwarder_OnEventCanUnregister(t *testing.T) {
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	var forwarder *Forwarder
+	forwarder = NewForwarder(func(e Event) {
+		forwarder.Unregister(cmd.Process.Pid)
+	})
+	forwarder.Register(cmd.Process.Pid, false, syscall.SIGUSR1)
+	done := make(chan bool)
+	go func() {
+		forwarder.Forward(syscall.SIGUSR1)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected OnEvent to be called without holding the lock")
+	}
+}
@@ -1 +1,3 @@
 module github.com/wojnosystems/gracefully
+
+go 1.20
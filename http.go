@@ -0,0 +1,167 @@
+package gracefully
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// defaultShutdownTimeout is how long in-flight work gets to finish when the routine is asked to stop, if not configured
+const defaultShutdownTimeout = 30 * time.Second
+
+// HTTPServerOptions configures the routine created by HTTPServer
+type HTTPServerOptions struct {
+	// Network to listen on, defaults to "tcp"
+	Network string
+	// Address to listen on, defaults to the Addr of the server from newServer, or ":http" if that is empty too
+	Address string
+	// ShutdownTimeout is how long active connections get to finish once the routine is asked to stop.
+	// Connections still open after this are closed. Defaults to 30 seconds
+	ShutdownTimeout time.Duration
+	// OnEvent, if set, is told when connections had to be closed while restarting, with an *HTTPShutdownError
+	OnEvent EventHandler
+}
+
+// HTTPShutdownError is returned by the HTTPServer routine when connections did not finish within the ShutdownTimeout and had to be closed
+type HTTPShutdownError struct {
+	// Forced is how many connections were closed before they finished
+	Forced int
+	// Err is why the graceful shutdown gave up, usually context.DeadlineExceeded
+	Err error
+}
+
+// Error describes how many connections were cut off
+func (e *HTTPShutdownError) Error() string {
+	return fmt.Sprintf("gracefully: http server forcibly closed %d connection(s): %v", e.Forced, e.Err)
+}
+
+// Unwrap gets the reason the graceful shutdown gave up
+func (e *HTTPShutdownError) Unwrap() error {
+	return e.Err
+}
+
+// HTTPServer creates a routine for ServiceManager.Start or Run that serves until the iteration's context is done.
+// The socket is opened with Listen, so it survives restarts. When asked to stop, keep-alives are disabled and idle
+// connections are closed, then active connections get ShutdownTimeout to finish before they are closed. If any had to
+// be closed, an *HTTPShutdownError is returned, which stops the ServiceManager like any other error. When restarting,
+// it's reported to OnEvent instead, and the routine returns nil so that it's started again.
+//
+// An http.Server can only be shut down once, so newServer is called to create a server for each iteration. Its
+// ConnState is wrapped to keep track of connections. If it has a TLSConfig with certificates, connections are served
+// over TLS
+func HTTPServer(newServer func() *http.Server, opts HTTPServerOptions) func(ctx context.Context) error {
+	if opts.Network == "" {
+		opts.Network = "tcp"
+	}
+	if opts.ShutdownTimeout <= 0 {
+		opts.ShutdownTimeout = defaultShutdownTimeout
+	}
+	return func(ctx context.Context) error {
+		srv := newServer()
+		address := opts.Address
+		if address == "" {
+			address = srv.Addr
+		}
+		if address == "" {
+			address = ":http"
+		}
+		l, err := Listen(ctx, opts.Network, address)
+		if err != nil {
+			return err
+		}
+		conns := newConnTracker()
+		trackConns(srv, conns)
+
+		serveDone := make(chan error, 1)
+		go func() {
+			if hasTLSCertificates(srv) {
+				serveDone <- srv.ServeTLS(l, "", "")
+			} else {
+				serveDone <- srv.Serve(l)
+			}
+		}()
+
+		select {
+		case err = <-serveDone:
+			// Server stopped on its own, this is not something we asked for
+			return err
+		case <-ctx.Done():
+		}
+
+		// Stop reusing connections so clients go elsewhere as soon as their current request is done
+		srv.SetKeepAlivesEnabled(false)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), opts.ShutdownTimeout)
+		defer cancel()
+		err = srv.Shutdown(shutdownCtx)
+		if err != nil {
+			forced := conns.open()
+			_ = srv.Close()
+			<-serveDone
+			shutdownErr := &HTTPShutdownError{Forced: forced, Err: err}
+			if !iterationComesBack(ctx) {
+				return shutdownErr
+			}
+			// Cutting connections off is no reason not to come back, from a restart or a pause
+			if opts.OnEvent != nil {
+				opts.OnEvent(Event{Source: "http", Message: "connections closed to restart", Err: shutdownErr})
+			}
+			return nil
+		}
+		if err = <-serveDone; !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	}
+}
+
+// hasTLSCertificates is true if srv was configured to serve TLS without certificate files
+func hasTLSCertificates(srv *http.Server) bool {
+	return srv.TLSConfig != nil && (len(srv.TLSConfig.Certificates) > 0 || srv.TLSConfig.GetCertificate != nil || srv.TLSConfig.GetConfigForClient != nil)
+}
+
+// trackConns has conns told about every connection srv opens, then whatever srv's ConnState was already set to
+func trackConns(srv *http.Server, conns *connTracker) {
+	userConnState := srv.ConnState
+	srv.ConnState = func(conn net.Conn, state http.ConnState) {
+		conns.track(conn, state)
+		if userConnState != nil {
+			userConnState(conn, state)
+		}
+	}
+}
+
+// connTracker keeps track of the connections an http.Server has open, using its ConnState callback
+type connTracker struct {
+	mu    sync.Mutex
+	conns map[net.Conn]http.ConnState
+}
+
+// newConnTracker creates a connTracker with no connections
+func newConnTracker() *connTracker {
+	return &connTracker{
+		conns: make(map[net.Conn]http.ConnState),
+	}
+}
+
+// track records the new state of conn. Connections that were closed or hijacked are no longer the server's to close
+func (c *connTracker) track(conn net.Conn, state http.ConnState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch state {
+	case http.StateClosed, http.StateHijacked:
+		delete(c.conns, conn)
+	default:
+		c.conns[conn] = state
+	}
+}
+
+// open is how many connections are currently open
+func (c *connTracker) open() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.conns)
+}
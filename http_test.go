@@ -0,0 +1,144 @@
+package gracefully
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestHTTPServer_ForcedClose(t *testing.T) {
+	sm := New()
+	cs := NewContextSignal()
+	sm.AddSignaler(cs)
+	// Claim the socket up front so we know which port the routine will serve on
+	l, err := sm.Listeners().Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	_ = l.Close()
+
+	inHandler := make(chan bool)
+	release := make(chan bool)
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			inHandler <- true
+			<-release
+		}),
+	}
+	go func() {
+		_, _ = http.Get("http://" + addr + "/")
+	}()
+	go func() {
+		<-inHandler
+		cs.Stop()
+	}()
+	err = sm.Run(HTTPServer(func() *http.Server { return srv }, HTTPServerOptions{Address: "127.0.0.1:0", ShutdownTimeout: time.Second / 10}))
+	close(release)
+	var shutdownErr *HTTPShutdownError
+	if !errors.As(err, &shutdownErr) {
+		t.Fatal("expected an HTTPShutdownError, got: ", err)
+	}
+	if shutdownErr.Forced != 1 {
+		t.Error("expected 1 connection to be forcibly closed, got: ", shutdownErr.Forced)
+	}
+}
+
+func TestHTTPServer_GracefulStop(t *testing.T) {
+	sm := New()
+	cs := NewContextSignal()
+	sm.AddSignaler(cs)
+	l, err := sm.Listeners().Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	_ = l.Close()
+
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Stopping must let this request finish
+			cs.Stop()
+			time.Sleep(time.Second / 20)
+			w.WriteHeader(http.StatusNoContent)
+		}),
+	}
+	status := make(chan int, 1)
+	go func() {
+		resp, getErr := http.Get("http://" + addr + "/")
+		if getErr != nil {
+			status <- 0
+			return
+		}
+		_ = resp.Body.Close()
+		status <- resp.StatusCode
+	}()
+	err = sm.Run(HTTPServer(func() *http.Server { return srv }, HTTPServerOptions{Address: "127.0.0.1:0", ShutdownTimeout: time.Second}))
+	if err != nil {
+		t.Error(err)
+	}
+	if code := <-status; code != http.StatusNoContent {
+		t.Error("expected in-flight request to complete, got status: ", code)
+	}
+}
+
+func TestHTTPServer_ForcedCloseWhileRestarting(t *testing.T) {
+	sm := New()
+	cs := NewContextSignal()
+	sm.AddSignaler(cs)
+	l, err := sm.Listeners().Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	_ = l.Close()
+
+	inHandler := make(chan bool)
+	release := make(chan bool)
+	defer close(release)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inHandler <- true
+		<-release
+	})
+	go func() {
+		_, _ = http.Get("http://" + addr + "/")
+	}()
+	var reported error
+	servers := 0
+	routine := HTTPServer(func() *http.Server {
+		servers++
+		return &http.Server{Handler: handler}
+	}, HTTPServerOptions{
+		Address:         "127.0.0.1:0",
+		ShutdownTimeout: time.Second / 10,
+		OnEvent:         func(e Event) { reported = e.Err },
+	})
+	iterations := 0
+	err = sm.Run(func(ctx context.Context) error {
+		iterations++
+		if iterations == 1 {
+			go func() {
+				<-inHandler
+				cs.Restart()
+			}()
+		} else {
+			cs.Stop()
+		}
+		return routine(ctx)
+	})
+	if err != nil {
+		t.Error("expected the forced close to be no reason to stop, got: ", err)
+	}
+	if iterations != 2 {
+		t.Error("expected the routine to be restarted, got iterations: ", iterations)
+	}
+	if servers != 2 {
+		t.Error("expected a new server for each iteration, got: ", servers)
+	}
+	var shutdownErr *HTTPShutdownError
+	if !errors.As(reported, &shutdownErr) || shutdownErr.Forced != 1 {
+		t.Error("expected the forced close to be reported, got: ", reported)
+	}
+}
@@ -0,0 +1,138 @@
+package gracefully
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// iterationWatcher is implemented by signalers that need to know when iterations of the routine start and end
+type iterationWatcher interface {
+	// iterationStarted is called before the routine is called
+	iterationStarted()
+	// iterationEnded is called once the routine returned
+	iterationEnded()
+}
+
+// Idle is a SignalSelecter that tells the ServiceManager what to do once there was no activity for a while, for
+// on-demand workers that should go away when unused. Activity is reported with Touch.
+// Only time spent with the routine running counts, so draining, restarts and pauses do not use up the timeout, and every
+// iteration starts with the full timeout. Once the action was issued, activity starts the timeout over again.
+// Do not instantiate yourself, call: NewIdle
+type Idle struct {
+	BaseSignaler
+	timeout time.Duration
+	action  GracefulAction
+	// mu protects running, timer and generation
+	mu sync.Mutex
+	// running is true while an iteration runs and is not draining, which is when time counts
+	running bool
+	// timer counts down to the action, nil unless running, or once it fired
+	timer *time.Timer
+	// generation changes every time the timer is replaced, so that a timer firing as it's replaced is ignored
+	generation uint64
+}
+
+// NewIdle creates an Idle that issues action, usually GracefulStop or GracefulPause, once there was no activity for timeout
+func NewIdle(timeout time.Duration, action GracefulAction) *Idle {
+	return &Idle{
+		BaseSignaler: NewBaseSignaler(),
+		timeout:      timeout,
+		action:       action,
+	}
+}
+
+// Touch reports activity, the timeout starts over
+func (i *Idle) Touch() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.running {
+		i.arm()
+	}
+}
+
+// Cancel stops counting
+func (i *Idle) Cancel() {
+	if i.cancel() {
+		i.disarm()
+	}
+}
+
+// iterationStarted starts counting, from the full timeout
+func (i *Idle) iterationStarted() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	select {
+	case <-i.cancelled:
+		return
+	default:
+	}
+	i.running = true
+	i.arm()
+}
+
+// drainStarted stops counting, the iteration is winding down
+func (i *Idle) drainStarted() {
+	i.disarm()
+}
+
+// iterationEnded stops counting until the next iteration, in case it returned on its own
+func (i *Idle) iterationEnded() {
+	i.disarm()
+}
+
+// arm replaces the timer with one for the full timeout. Caller must hold mu
+func (i *Idle) arm() {
+	if i.timer != nil {
+		i.timer.Stop()
+	}
+	i.generation++
+	generation := i.generation
+	i.timer = time.AfterFunc(i.timeout, func() {
+		i.fire(generation)
+	})
+}
+
+// disarm stops counting until the next iteration
+func (i *Idle) disarm() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.running = false
+	if i.timer != nil {
+		i.timer.Stop()
+		i.timer = nil
+	}
+	i.generation++
+}
+
+// fire tells the ServiceManager it's been idle long enough, unless the timer it's for was replaced in the meantime
+func (i *Idle) fire(generation uint64) {
+	i.mu.Lock()
+	current := generation == i.generation
+	if current {
+		// Once is enough, until there is activity again or the next iteration starts
+		i.timer = nil
+	}
+	i.mu.Unlock()
+	if !current {
+		return
+	}
+	i.sendAction(i.action)
+}
+
+// Touch reports activity to every Idle of the ServiceManager running the routine ctx was handed to.
+// If ctx did not come from a ServiceManager, this does nothing
+func Touch(ctx context.Context) {
+	s := managerFromContext(ctx)
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	signalers := append([]SignalSelecter{}, s.signalers...)
+	s.mu.Unlock()
+	for _, si := range signalers {
+		if idle, ok := si.(*Idle); ok {
+			idle.Touch()
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package gracefully
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestIdle_StopsWithoutActivity(t *testing.T) {
+	sm := New()
+	sm.AddSignaler(NewIdle(30*time.Millisecond, GracefulStop))
+	started := time.Now()
+	var ran time.Duration
+	err := sm.Run(func(ctx context.Context) error {
+		// Busy for a while, then nothing
+		for i := 0; i < 5; i++ {
+			time.Sleep(10 * time.Millisecond)
+			Touch(ctx)
+		}
+		<-ctx.Done()
+		ran = time.Since(started)
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if ran < 80*time.Millisecond {
+		t.Error("expected activity to keep the service running, stopped after: ", ran)
+	}
+}
+
+func TestIdle_NotCountedWhilePaused(t *testing.T) {
+	sm := New()
+	cs := NewContextSignal()
+	idle := NewIdle(30*time.Millisecond, GracefulStop)
+	sm.AddSignaler(cs)
+	sm.AddSignaler(idle)
+	iterations := 0
+	err := sm.Run(func(ctx context.Context) error {
+		iterations++
+		if iterations == 1 {
+			cs.Pause()
+			<-ctx.Done()
+			go func() {
+				// Paused for longer than the timeout
+				time.Sleep(60 * time.Millisecond)
+				cs.Resume()
+			}()
+			return nil
+		}
+		// The second iteration gets the full timeout
+		started := time.Now()
+		<-ctx.Done()
+		if elapsed := time.Since(started); elapsed < 25*time.Millisecond {
+			t.Error("expected the full timeout after resuming, got: ", elapsed)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if iterations != 2 {
+		t.Error("expected 2 iterations, got: ", iterations)
+	}
+}
+
+func TestIdle_TouchWhileAddingSignalers(t *testing.T) {
+	sm := New()
+	cs := NewContextSignal()
+	sm.AddSignaler(cs)
+	err := sm.Run(func(ctx context.Context) error {
+		touched := make(chan struct{})
+		go func() {
+			defer close(touched)
+			for i := 0; i < 100; i++ {
+				Touch(ctx)
+			}
+		}()
+		// Signalers can be added while the routine reports activity, the race detector would tell
+		for i := 0; i < 100; i++ {
+			sm.AddSignaler(NewIdle(time.Minute, GracefulStop))
+		}
+		<-touched
+		cs.Stop()
+		<-ctx.Done()
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestIdle_TouchAfterFiringStartsOver(t *testing.T) {
+	idle := NewIdle(10*time.Millisecond, GracefulPause)
+	defer idle.Cancel()
+	idle.iterationStarted()
+	expectAction(t, idle, GracefulPause)
+	// Still running, the pause may well have been refused
+	idle.Touch()
+	expectAction(t, idle, GracefulPause)
+}
+
+func TestIdle_NotCountedWhileDraining(t *testing.T) {
+	sm := New()
+	cs := NewContextSignal()
+	sm.AddSignaler(cs)
+	sm.AddSignaler(NewIdle(30*time.Millisecond, GracefulStop))
+	iterations := 0
+	err := sm.Run(func(ctx context.Context) error {
+		iterations++
+		if iterations == 1 {
+			done, err := Track(ctx)
+			if err != nil {
+				return err
+			}
+			go func() {
+				// Draining for longer than the timeout
+				time.Sleep(60 * time.Millisecond)
+				done()
+			}()
+			cs.Restart()
+		}
+		<-ctx.Done()
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if iterations != 2 {
+		t.Error("expected the restart to go ahead, got iterations: ", iterations)
+	}
+}
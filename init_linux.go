@@ -0,0 +1,212 @@
+package gracefully
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// prSetChildSubreaper is PR_SET_CHILD_SUBREAPER from linux/prctl.h
+const prSetChildSubreaper = 36
+
+// reapEvery is how often zombies are looked for, even without SIGCHLD
+const reapEvery = time.Second
+
+// reaper waits on the orphaned descendants that were re-parented to this process, see ServiceManager.EnableInitMode
+type reaper struct {
+	// self is our pid, the parent of the zombies we reap
+	self    int
+	sigchld chan os.Signal
+	// done is closed to stop reaping
+	done     chan struct{}
+	reaping  sync.WaitGroup
+	stopOnce sync.Once
+}
+
+// newReaper makes this process the child subreaper of its descendants, so orphans are re-parented to us rather than
+// to PID 1. When we are PID 1, they already are
+func newReaper() (*reaper, error) {
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetChildSubreaper, 1, 0); errno != 0 {
+		return nil, os.NewSyscallError("prctl", errno)
+	}
+	return &reaper{
+		self:    os.Getpid(),
+		sigchld: make(chan os.Signal, 1),
+		done:    make(chan struct{}),
+	}, nil
+}
+
+// start reaps zombies every time a child exits. SIGCHLD can be coalesced, so every zombie is looked for every time,
+// and once in a while just in case
+func (r *reaper) start() {
+	signal.Notify(r.sigchld, syscall.SIGCHLD)
+	r.reaping.Add(1)
+	go func() {
+		defer r.reaping.Done()
+		ticker := time.NewTicker(reapEvery)
+		defer ticker.Stop()
+		for {
+			r.reap()
+			select {
+			case <-r.sigchld:
+			case <-ticker.C:
+			case <-r.done:
+				return
+			}
+		}
+	}()
+}
+
+// stop stops reaping
+func (r *reaper) stop() {
+	r.stopOnce.Do(func() {
+		signal.Stop(r.sigchld)
+		close(r.done)
+		r.reaping.Wait()
+	})
+}
+
+// reap waits on our zombie children, except those something else in this process is going to wait on
+func (r *reaper) reap() {
+	zombies := r.zombies()
+	if len(zombies) == 0 {
+		return
+	}
+	// Looked up after the zombies: a process exec.Cmd started is still ours until its Wait is done with it
+	owned := ownedPids()
+	for _, pid := range zombies {
+		if owned[pid] {
+			continue
+		}
+		var status syscall.WaitStatus
+		// Only that pid, so that we can never take the exit status of another child
+		_, _ = syscall.Wait4(pid, &status, syscall.WNOHANG, nil)
+	}
+}
+
+// terminate sends SIGTERM to all our descendants, then waits for them to be gone, reaping them, up to timeout
+func (r *reaper) terminate(timeout time.Duration) {
+	for _, pid := range r.descendants() {
+		_ = syscall.Kill(pid, syscall.SIGTERM)
+	}
+	deadline := time.Now().Add(timeout)
+	for len(r.descendants()) != 0 && time.Now().Before(deadline) {
+		r.reap()
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// zombies are our children that exited and were not waited on yet
+func (r *reaper) zombies() []int {
+	zombies := make([]int, 0)
+	for _, p := range processTable() {
+		if p.ppid == r.self && p.state == 'Z' {
+			zombies = append(zombies, p.pid)
+		}
+	}
+	return zombies
+}
+
+// descendants are all the processes below us that are still running, zombies excluded
+func (r *reaper) descendants() []int {
+	children := make(map[int][]procStat)
+	for _, p := range processTable() {
+		children[p.ppid] = append(children[p.ppid], p)
+	}
+	found := make([]int, 0)
+	pending := []int{r.self}
+	for len(pending) != 0 {
+		pid := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		for _, child := range children[pid] {
+			pending = append(pending, child.pid)
+			if child.state != 'Z' {
+				found = append(found, child.pid)
+			}
+		}
+	}
+	return found
+}
+
+// procStat is what we need from /proc/<pid>/stat
+type procStat struct {
+	pid   int
+	ppid  int
+	state byte
+}
+
+// processTable lists the processes we can see in /proc
+func processTable() []procStat {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+	table := make([]procStat, 0, len(entries))
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join("/proc", entry.Name(), "stat"))
+		if err != nil {
+			// Gone in the meantime
+			continue
+		}
+		if p, err := parseProcStat(pid, data); err == nil {
+			table = append(table, p)
+		}
+	}
+	return table
+}
+
+// parseProcStat parses "pid (comm) state ppid ...". comm may contain spaces and parentheses, so it ends at the last ')'
+func parseProcStat(pid int, data []byte) (procStat, error) {
+	end := bytes.LastIndexByte(data, ')')
+	if end < 0 {
+		return procStat{}, errors.New("malformed stat")
+	}
+	fields := strings.Fields(string(data[end+1:]))
+	if len(fields) < 2 || len(fields[0]) != 1 {
+		return procStat{}, errors.New("malformed stat")
+	}
+	ppid, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return procStat{}, err
+	}
+	return procStat{pid: pid, ppid: ppid, state: fields[0][0]}, nil
+}
+
+// ownedPids are the processes this process holds a pidfd to. The os package keeps one for every process it starts
+// until Wait is done with it, which is how we avoid taking the exit status an exec.Cmd is waiting for
+func ownedPids() map[int]bool {
+	owned := make(map[int]bool)
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return owned
+	}
+	for _, fd := range fds {
+		if link, err := os.Readlink(filepath.Join("/proc/self/fd", fd.Name())); err != nil || link != "anon_inode:[pidfd]" {
+			continue
+		}
+		info, err := os.ReadFile(filepath.Join("/proc/self/fdinfo", fd.Name()))
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(info), "\n") {
+			if !strings.HasPrefix(line, "Pid:") {
+				continue
+			}
+			if pid, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "Pid:"))); err == nil && pid > 0 {
+				owned[pid] = true
+			}
+		}
+	}
+	return owned
+}
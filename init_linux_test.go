@@ -0,0 +1,81 @@
+package gracefully
+
+import (
+	"context"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestServiceManager_InitModeReapsOrphans(t *testing.T) {
+	sm := New()
+	sm.AddSignaler(NewContextSignal())
+	if err := sm.EnableInitMode(); err != nil {
+		t.Skip("init mode unavailable: ", err)
+	}
+	orphan := 0
+	exitCode := 0
+	err := sm.Run(func(ctx context.Context) error {
+		// The shell exits right away, leaving a short-lived orphan re-parented to us
+		out, err := exec.Command("/bin/sh", "-c", "sleep 0.2 & echo $!").Output()
+		if err != nil {
+			return err
+		}
+		orphan, _ = strconv.Atoi(strings.TrimSpace(string(out)))
+
+		// Our own child exits while the reaper is running, its exit status is still ours to get
+		cmd := exec.Command("/bin/sh", "-c", "exit 3")
+		if err := cmd.Start(); err != nil {
+			return err
+		}
+		time.Sleep(3 * reapEvery / 2)
+		if err := cmd.Wait(); err != nil {
+			exitCode = err.(*exec.ExitError).ExitCode()
+		}
+		return context.Canceled
+	})
+	if err != context.Canceled {
+		t.Error("unexpected error: ", err)
+	}
+	if exitCode != 3 {
+		t.Error("expected to get the exit code of our child, got: ", exitCode)
+	}
+	if orphan == 0 {
+		t.Fatal("orphan not started")
+	}
+	if err := syscall.Kill(orphan, 0); err != syscall.ESRCH {
+		t.Error("expected the orphan to be reaped, got: ", err)
+	}
+}
+
+func TestServiceManager_InitModeTerminatesDescendants(t *testing.T) {
+	sm := New()
+	cs := NewContextSignal()
+	sm.AddSignaler(cs)
+	if err := sm.EnableInitMode(); err != nil {
+		t.Skip("init mode unavailable: ", err)
+	}
+	orphan := 0
+	err := sm.Run(func(ctx context.Context) error {
+		out, err := exec.Command("/bin/sh", "-c", "sleep 100 > /dev/null 2>&1 & echo $!").Output()
+		if err != nil {
+			return err
+		}
+		orphan, _ = strconv.Atoi(strings.TrimSpace(string(out)))
+		cs.Stop()
+		<-ctx.Done()
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if orphan == 0 {
+		t.Fatal("orphan not started")
+	}
+	if err := syscall.Kill(orphan, 0); err != syscall.ESRCH {
+		t.Error("expected the orphan to be terminated and reaped, got: ", err)
+	}
+}
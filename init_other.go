@@ -0,0 +1,22 @@
+//go:build !linux
+// +build !linux
+
+package gracefully
+
+import (
+	"time"
+)
+
+// reaper is only available on linux
+type reaper struct{}
+
+// newReaper always fails, there is no child subreaper outside of linux
+func newReaper() (*reaper, error) {
+	return nil, ErrInitModeUnsupported
+}
+
+func (r *reaper) start() {}
+
+func (r *reaper) stop() {}
+
+func (r *reaper) terminate(time.Duration) {}
@@ -1,5 +1,10 @@
 package gracefully
 
+import (
+	"os"
+	"sync"
+)
+
 // GracefulAction is how we tell the service what to do after some sort of interrupt is handled
 type GracefulAction uint8
 
@@ -8,11 +13,24 @@ const (
 	GracefulRestart GracefulAction = iota
 	// GracefulStop : signal to the iteration/ServiceManager that it's time to stop. Wait/Run will eventually unblock after the internal GoRoutine has ended
 	GracefulStop
+	// GracefulUpgrade : signal to the ServiceManager that it's time to hand the process over to a new copy of the binary. If the Upgrader configured with SetUpgrader succeeds, this behaves like GracefulStop, otherwise the service keeps running as if nothing happened
+	GracefulUpgrade
+	// GracefulPause : signal to the ServiceManager that the routine should stop, like GracefulStop, but that Wait/Run should remain blocked until GracefulResume or GracefulRestart starts it again. Ignored unless running
+	GracefulPause
+	// GracefulResume : signal to the ServiceManager that the routine paused by GracefulPause should be started again. Ignored unless paused
+	GracefulResume
+	// GracefulNone : signal to the ServiceManager that nothing needs to happen, for signals that were already handled, such as by a SignalHandler Callback
+	GracefulNone
 )
 
 // SignalControl is called back by the thread that called "Wait" or "Run" and executed. This callback is provided the pointer to the service for reference
 type SignalControl func(*ServiceManager) GracefulAction
 
+// Upgrader replaces the running process with a new one. Upgrade should only return nil once the replacement is ready to take over, at which point the ServiceManager will stop. If an error is returned, the ServiceManager keeps running
+type Upgrader interface {
+	Upgrade() error
+}
+
 type SignalSelecter interface {
 	// Select will be called for this signaler when the ServiceManager is in the Wait state.
 	// This channel will never be written to by the receiver, so a read-only channel is returned, but you'll need to
@@ -30,21 +48,73 @@ type SignalSelecter interface {
 	Cancel()
 }
 
+// Event describes something that happened in a signaler or helper that is worth knowing about, but did not change the state of the ServiceManager, such as a failure that was worked around
+type Event struct {
+	// Source names what emitted the event, such as "forwarder"
+	Source string
+	// Signal is the operating system signal involved, if any
+	Signal os.Signal
+	// Message describes what happened
+	Message string
+	// Err is what went wrong, nil if this is not a failure
+	Err error
+}
+
+// EventHandler is called with the events emitted by signalers and helpers. It should return quickly
+type EventHandler func(Event)
+
 type BaseSignaler struct {
 	// OnSignal: push a function callback to this when you need to signal to ServiceManager to shutdown
 	OnSignal chan SignalControl
 	// You will receive on this channel when the ServiceManager wants you to shutdown
 	OnCancel chan bool
+	// cancelled is closed by cancel, for signalers that stop their own goroutines with it rather than OnCancel
+	cancelled chan struct{}
+	// cancelOnce closes cancelled only once, however many times Cancel is called
+	cancelOnce sync.Once
 	SignalSelecter
 }
 
 func NewBaseSignaler() BaseSignaler {
 	return BaseSignaler{
-		OnCancel: make(chan bool, 1),
-		OnSignal: make(chan SignalControl, 1),
+		OnCancel:  make(chan bool, 1),
+		OnSignal:  make(chan SignalControl, 1),
+		cancelled: make(chan struct{}),
 	}
 }
 
+// cancel closes cancelled, for the Cancel of signalers using it
+// @return true the first time, so that whatever else the signaler needs to clean up is only cleaned up once
+func (s *BaseSignaler) cancel() (first bool) {
+	s.cancelOnce.Do(func() {
+		// Not made by NewBaseSignaler, there is nobody to tell
+		if s.cancelled != nil {
+			close(s.cancelled)
+		}
+		first = true
+	})
+	return
+}
+
+// send hands control to the ServiceManager, unless cancel is called in the meantime
+// @return false if cancelled
+func (s *BaseSignaler) send(control SignalControl) bool {
+	select {
+	case s.OnSignal <- control:
+		return true
+	case <-s.cancelled:
+		return false
+	}
+}
+
+// sendAction tells the ServiceManager to take action, unless cancel is called in the meantime
+// @return false if cancelled
+func (s *BaseSignaler) sendAction(action GracefulAction) bool {
+	return s.send(func(manager *ServiceManager) GracefulAction {
+		return action
+	})
+}
+
 // Cancel is called when it's time to clean up the service
 func (s *BaseSignaler) Cancel() {
 	s.OnCancel <- true
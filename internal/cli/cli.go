@@ -0,0 +1,141 @@
+//go:build !windows
+// +build !windows
+
+// Package cli holds what the gracefully commands have in common: parsing signals and restart policies, and turning how a child process ended into an exit status
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/wojnosystems/gracefully"
+)
+
+// signalNames are the signals that can be named on the command line, without the SIG prefix
+var signalNames = map[string]syscall.Signal{
+	"HUP":   syscall.SIGHUP,
+	"INT":   syscall.SIGINT,
+	"QUIT":  syscall.SIGQUIT,
+	"KILL":  syscall.SIGKILL,
+	"USR1":  syscall.SIGUSR1,
+	"USR2":  syscall.SIGUSR2,
+	"TERM":  syscall.SIGTERM,
+	"CONT":  syscall.SIGCONT,
+	"STOP":  syscall.SIGSTOP,
+	"TSTP":  syscall.SIGTSTP,
+	"TTIN":  syscall.SIGTTIN,
+	"TTOU":  syscall.SIGTTOU,
+	"WINCH": syscall.SIGWINCH,
+	"ALRM":  syscall.SIGALRM,
+	"PIPE":  syscall.SIGPIPE,
+}
+
+// ParseSignal gets the signal named, such as TERM, SIGTERM or 15
+func ParseSignal(name string) (os.Signal, error) {
+	name = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(name)), "SIG")
+	if sig, ok := signalNames[name]; ok {
+		return sig, nil
+	}
+	var n int
+	if _, err := fmt.Sscanf(name, "%d", &n); err == nil && n > 0 {
+		return syscall.Signal(n), nil
+	}
+	return nil, fmt.Errorf("unknown signal: %s", name)
+}
+
+// ParseSignals gets the signals in a comma-separated list, an empty list has no signals
+func ParseSignals(names string) ([]os.Signal, error) {
+	sigs := make([]os.Signal, 0)
+	for _, name := range strings.Split(names, ",") {
+		if strings.TrimSpace(name) == "" {
+			continue
+		}
+		sig, err := ParseSignal(name)
+		if err != nil {
+			return nil, err
+		}
+		sigs = append(sigs, sig)
+	}
+	return sigs, nil
+}
+
+// RestartFlags are the command line flags that configure a gracefully.Restarts
+type RestartFlags struct {
+	mode           string
+	backoff        string
+	backoffInitial time.Duration
+	backoffMax     time.Duration
+	maxRestarts    int
+}
+
+// Register adds the flags to fs
+func (r *RestartFlags) Register(fs *flag.FlagSet) {
+	fs.StringVar(&r.mode, "restart", "no", "when to restart the process once it exits: no, on-failure or always")
+	fs.StringVar(&r.backoff, "backoff", "exp", "how long to wait between restarts: none, constant or exp")
+	fs.DurationVar(&r.backoffInitial, "backoff-initial", time.Second, "wait before the first restart, doubled each time for exp")
+	fs.DurationVar(&r.backoffMax, "backoff-max", time.Minute, "longest wait between restarts for exp")
+	fs.IntVar(&r.maxRestarts, "max-restarts", 0, "give up after this many restarts in a row, 0 for no limit")
+}
+
+// Restarts builds the restart policy the flags describe
+func (r *RestartFlags) Restarts() (policy gracefully.Restarts, err error) {
+	switch r.mode {
+	case "no":
+		policy.Mode = gracefully.RestartNever
+	case "on-failure":
+		policy.Mode = gracefully.RestartOnFailure
+	case "always":
+		policy.Mode = gracefully.RestartAlways
+	default:
+		return policy, fmt.Errorf("unknown restart policy: %s", r.mode)
+	}
+	switch r.backoff {
+	case "none":
+	case "constant":
+		policy.Backoff = gracefully.ConstantBackoff(r.backoffInitial)
+	case "exp":
+		policy.Backoff = gracefully.ExponentialBackoff(r.backoffInitial, r.backoffMax)
+	default:
+		return policy, fmt.Errorf("unknown backoff: %s", r.backoff)
+	}
+	policy.MaxAttempts = r.maxRestarts
+	return policy, nil
+}
+
+// ExitCode is the exit status a shell would report for a process that ended with state: its exit code, or 128 plus the signal that terminated it
+func ExitCode(state *os.ProcessState) int {
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return state.ExitCode()
+}
+
+// StartFailureCode is the exit status a shell would report when a process could not be started because of err
+func StartFailureCode(err error) int {
+	switch {
+	case errors.Is(err, exec.ErrNotFound), errors.Is(err, os.ErrNotExist):
+		return 127
+	case errors.Is(err, os.ErrPermission):
+		return 126
+	}
+	return 1
+}
+
+// ErrorCode is the exit status a shell would report for a process whose routine returned err: 0 for nil, how the
+// process ended for a *gracefully.ExitError, otherwise as StartFailureCode
+func ErrorCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return ExitCode(exitErr.ProcessState)
+	}
+	return StartFailureCode(err)
+}
@@ -0,0 +1,152 @@
+//go:build !windows
+// +build !windows
+
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+
+	"github.com/wojnosystems/gracefully"
+)
+
+func TestParseSignal(t *testing.T) {
+	cases := []struct {
+		name     string
+		expected os.Signal
+	}{
+		{"TERM", syscall.SIGTERM},
+		{"SIGTERM", syscall.SIGTERM},
+		{" sighup ", syscall.SIGHUP},
+		{"usr2", syscall.SIGUSR2},
+		{"9", syscall.SIGKILL},
+	}
+	for _, c := range cases {
+		sig, err := ParseSignal(c.name)
+		if err != nil || sig != c.expected {
+			t.Error("expected ", c.expected, " for ", c.name, " got: ", sig, err)
+		}
+	}
+	for _, name := range []string{"", "SIG", "NOPE", "0", "-1"} {
+		if sig, err := ParseSignal(name); err == nil {
+			t.Error("expected an error for ", name, " got: ", sig)
+		}
+	}
+}
+
+func TestParseSignals(t *testing.T) {
+	sigs, err := ParseSignals("USR1, ,WINCH")
+	if err != nil || len(sigs) != 2 || sigs[0] != syscall.SIGUSR1 || sigs[1] != syscall.SIGWINCH {
+		t.Error("expected USR1 and WINCH, got: ", sigs, err)
+	}
+	if sigs, err = ParseSignals(""); err != nil || len(sigs) != 0 {
+		t.Error("expected no signals, got: ", sigs, err)
+	}
+	if _, err = ParseSignals("USR1,NOPE"); err == nil {
+		t.Error("expected an error for an unknown signal")
+	}
+}
+
+func TestRestartFlags(t *testing.T) {
+	cases := []struct {
+		args     []string
+		expected gracefully.RestartMode
+		valid    bool
+	}{
+		{nil, gracefully.RestartNever, true},
+		{[]string{"--restart=on-failure", "--backoff=none"}, gracefully.RestartOnFailure, true},
+		{[]string{"--restart=always", "--backoff=constant", "--max-restarts=3"}, gracefully.RestartAlways, true},
+		{[]string{"--restart=sometimes"}, 0, false},
+		{[]string{"--backoff=linear"}, 0, false},
+	}
+	for _, c := range cases {
+		var r RestartFlags
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		r.Register(fs)
+		if err := fs.Parse(c.args); err != nil {
+			t.Fatal(err)
+		}
+		policy, err := r.Restarts()
+		if !c.valid {
+			if err == nil {
+				t.Error("expected an error for ", c.args)
+			}
+			continue
+		}
+		if err != nil || policy.Mode != c.expected {
+			t.Error("expected mode ", c.expected, " for ", c.args, " got: ", policy.Mode, err)
+		}
+	}
+}
+
+// processState runs script with sh and gets how it ended
+func processState(t *testing.T, script string) *os.ProcessState {
+	t.Helper()
+	cmd := exec.Command("sh", "-c", script)
+	_ = cmd.Run()
+	if cmd.ProcessState == nil {
+		t.Fatal("expected sh to run")
+	}
+	return cmd.ProcessState
+}
+
+func TestExitCode(t *testing.T) {
+	cases := []struct {
+		script   string
+		expected int
+	}{
+		{"exit 0", 0},
+		{"exit 3", 3},
+		{"kill -TERM $$", 128 + int(syscall.SIGTERM)},
+		{"kill -KILL $$", 128 + int(syscall.SIGKILL)},
+	}
+	for _, c := range cases {
+		if code := ExitCode(processState(t, c.script)); code != c.expected {
+			t.Error("expected ", c.expected, " for ", c.script, " got: ", code)
+		}
+	}
+}
+
+func TestStartFailureCode(t *testing.T) {
+	cases := []struct {
+		err      error
+		expected int
+	}{
+		{exec.ErrNotFound, 127},
+		{&os.PathError{Op: "fork/exec", Path: "./missing", Err: syscall.ENOENT}, 127},
+		{&os.PathError{Op: "fork/exec", Path: "./script", Err: syscall.EACCES}, 126},
+		{fmt.Errorf("wrapped: %w", exec.ErrNotFound), 127},
+		{errors.New("something else"), 1},
+	}
+	for _, c := range cases {
+		if code := StartFailureCode(c.err); code != c.expected {
+			t.Error("expected ", c.expected, " for ", c.err, " got: ", code)
+		}
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	exited := exec.Command("sh", "-c", "exit 5").Run()
+	killed := exec.Command("sh", "-c", "kill -KILL $$").Run()
+	cases := []struct {
+		err      error
+		expected int
+	}{
+		{nil, 0},
+		{exited, 5},
+		{&gracefully.ExitError{Code: 5, Err: exited}, 5},
+		{&gracefully.ExitError{Code: -1, Signal: syscall.SIGKILL, Err: killed}, 128 + int(syscall.SIGKILL)},
+		{exec.ErrNotFound, 127},
+		{errors.New("something else"), 1},
+	}
+	for _, c := range cases {
+		if code := ErrorCode(c.err); code != c.expected {
+			t.Error("expected ", c.expected, " for ", c.err, " got: ", code)
+		}
+	}
+}
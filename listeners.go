@@ -0,0 +1,275 @@
+package gracefully
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Listeners is a registry of network listeners that outlive a single iteration of the routine.
+// When the routine asks for a listener it already asked for in a previous iteration, the same socket is handed back,
+// so that restarting does not refuse any connections. Closing a listener returned by Listen only stops that
+// iteration from accepting on it, the socket itself is closed when the ServiceManager reaches StateDead.
+//
+// Do not instantiate yourself, call: NewListeners or use ServiceManager.Listeners
+type Listeners struct {
+	// mu protects all fields below
+	mu sync.Mutex
+	// sockets are the real listeners, keyed by network:address
+	sockets map[string]*sharedSocket
+	// order is the order sockets were created in, to keep file descriptors stable when handing them to another process
+	order []string
+	// adopted are the keys of sockets given to us by another process that were not claimed by Listen yet
+	adopted map[string]bool
+	// closed is true once Close was called, no more listeners may be created
+	closed bool
+}
+
+// ErrListenersClosed is returned by Listen once the registry has been closed
+var ErrListenersClosed = errors.New("gracefully: listeners are closed")
+
+// deadliner is implemented by listeners that can have their Accept calls interrupted without being closed
+type deadliner interface {
+	SetDeadline(t time.Time) error
+}
+
+// NewListeners creates a new, empty, Listeners registry
+func NewListeners() *Listeners {
+	return &Listeners{
+		sockets: make(map[string]*sharedSocket),
+		adopted: make(map[string]bool),
+	}
+}
+
+// Listen announces on the local network address, the same as net.Listen. If this registry already has a socket for the
+// same network and address, it's re-used instead of binding a new one.
+func (r *Listeners) Listen(network, address string) (net.Listener, error) {
+	key := listenerKey(network, address)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil, ErrListenersClosed
+	}
+	socket, ok := r.sockets[key]
+	if ok {
+		delete(r.adopted, key)
+	} else {
+		l, err := net.Listen(network, address)
+		if err != nil {
+			return nil, err
+		}
+		if socket, err = r.add(key, l); err != nil {
+			_ = l.Close()
+			return nil, err
+		}
+	}
+	return &iterationListener{Listener: socket.Listener, socket: socket}, nil
+}
+
+// Close closes all of the sockets in the registry. Listen will fail from now on
+func (r *Listeners) Close() (err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	for _, key := range r.order {
+		if closeErr := r.sockets[key].Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	r.sockets = make(map[string]*sharedSocket)
+	r.adopted = make(map[string]bool)
+	r.order = nil
+	return
+}
+
+// add records a new socket in the registry. Caller must hold mu
+func (r *Listeners) add(key string, l net.Listener) (*sharedSocket, error) {
+	d, ok := l.(deadliner)
+	if !ok {
+		return nil, fmt.Errorf("gracefully: listener %s does not support deadlines and cannot be shared across iterations", key)
+	}
+	socket := &sharedSocket{Listener: l, deadliner: d}
+	socket.cond = sync.NewCond(&socket.mu)
+	r.sockets[key] = socket
+	r.order = append(r.order, key)
+	return socket, nil
+}
+
+// adopt adds a socket handed over by another process. It will be used the next time Listen is called with the same network and address
+func (r *Listeners) adopt(key string, l net.Listener) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.add(key, l); err != nil {
+		return err
+	}
+	r.adopted[key] = true
+	return nil
+}
+
+// closeAdopted closes sockets handed over by another process that nobody asked for
+func (r *Listeners) closeAdopted() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	order := r.order[:0]
+	for _, key := range r.order {
+		if r.adopted[key] {
+			_ = r.sockets[key].Close()
+			delete(r.sockets, key)
+			delete(r.adopted, key)
+			continue
+		}
+		order = append(order, key)
+	}
+	r.order = order
+}
+
+// fileListener is implemented by the net.Listeners that can be handed to another process
+type fileListener interface {
+	File() (*os.File, error)
+}
+
+// files duplicates the file descriptors of all sockets, in the order they were created, so they can be given to another process
+func (r *Listeners) files() (keys []string, files []*os.File, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, key := range r.order {
+		fl, ok := r.sockets[key].Listener.(fileListener)
+		if !ok {
+			return keys, files, fmt.Errorf("gracefully: listener %s cannot be handed to a new process", key)
+		}
+		f, fileErr := fl.File()
+		if fileErr != nil {
+			return keys, files, fileErr
+		}
+		keys = append(keys, key)
+		files = append(files, f)
+	}
+	return
+}
+
+// keepUnixSocketFiles prevents Close from removing the files of unix sockets, as another process now owns them
+func (r *Listeners) keepUnixSocketFiles() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, socket := range r.sockets {
+		if ul, ok := socket.Listener.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+}
+
+// listenerKey is how sockets are identified in the registry and when handed to another process
+func listenerKey(network, address string) string {
+	return network + ":" + address
+}
+
+// sharedSocket is a socket of the registry, with what is needed to interrupt the Accept of one iteration without
+// disturbing the others
+type sharedSocket struct {
+	net.Listener
+	deadliner
+	// mu protects everything below, and the iterationListeners of this socket
+	mu sync.Mutex
+	// cond is signalled when an interruption is over
+	cond *sync.Cond
+	// interrupting counts the Accept calls of closed views that did not return yet. The deadline is in the past while there
+	// are any, and reset once the last one returned
+	interrupting int
+	// interruptions counts the interruptions, so that views can tell being interrupted apart from other timeouts
+	interruptions uint64
+}
+
+// iterationListener is the view of a shared socket given to a single iteration of the routine.
+// Closing it interrupts its own Accept calls without closing the socket, other views keep accepting
+type iterationListener struct {
+	net.Listener
+	socket *sharedSocket
+	// closed and accepting are protected by socket.mu
+	closed bool
+	// accepting counts the Accept calls in progress
+	accepting int
+}
+
+// Accept waits for and returns the next connection, until Close is called
+func (l *iterationListener) Accept() (net.Conn, error) {
+	s := l.socket
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		// Another view is being interrupted, starting now would get us interrupted too
+		for s.interrupting > 0 && !l.closed {
+			s.cond.Wait()
+		}
+		if l.closed {
+			return nil, l.closedError("accept")
+		}
+		l.accepting++
+		interruptions := s.interruptions
+		s.mu.Unlock()
+		conn, err := s.Listener.Accept()
+		s.mu.Lock()
+		l.accepting--
+		if l.closed {
+			// Close counted us as being interrupted, the last one to return lets the other views block again
+			s.interrupting--
+			if s.interrupting == 0 {
+				_ = s.SetDeadline(time.Time{})
+				s.cond.Broadcast()
+			}
+			if err != nil {
+				return nil, l.closedError("accept")
+			}
+			// Accepted before the interruption, it's not ours to drop
+			return conn, nil
+		}
+		if err != nil && errors.Is(err, os.ErrDeadlineExceeded) && s.interruptions != interruptions {
+			// Interrupted for the sake of another view, try again once that's over
+			continue
+		}
+		return conn, err
+	}
+}
+
+// Close stops this iteration from accepting connections. Its blocked Accept calls are interrupted, those of other views
+// of the same socket are not. The socket remains open
+func (l *iterationListener) Close() error {
+	s := l.socket
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if l.closed {
+		return l.closedError("close")
+	}
+	l.closed = true
+	if l.accepting == 0 {
+		// Nothing to interrupt, the socket is left alone
+		return nil
+	}
+	s.interrupting += l.accepting
+	s.interruptions++
+	err := s.SetDeadline(time.Now())
+	// The socket may already be closed if the ServiceManager is dead, that's fine
+	if errors.Is(err, net.ErrClosed) || errors.Is(err, syscall.EINVAL) {
+		return nil
+	}
+	return err
+}
+
+// closedError is the error for op on a closed view
+func (l *iterationListener) closedError(op string) error {
+	return &net.OpError{Op: op, Net: l.Addr().Network(), Addr: l.Addr(), Err: net.ErrClosed}
+}
+
+// Listen announces on the local network address using the Listeners of the ServiceManager running the routine ctx was handed to.
+// The same socket is returned across GracefulRestart iterations and is only closed once the ServiceManager is dead.
+// If ctx did not come from a ServiceManager, this is the same as net.Listen
+func Listen(ctx context.Context, network, address string) (net.Listener, error) {
+	if s := managerFromContext(ctx); s != nil {
+		return s.Listeners().Listen(network, address)
+	}
+	return net.Listen(network, address)
+}
@@ -0,0 +1,157 @@
+package gracefully
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestListen_SameSocketAcrossRestarts(t *testing.T) {
+	sm := New()
+	cs := NewContextSignal()
+	sm.AddSignaler(cs)
+	addrs := make([]string, 0, 2)
+	err := sm.Run(func(iCtx context.Context) error {
+		l, err := Listen(iCtx, "tcp", "127.0.0.1:0")
+		if err != nil {
+			return err
+		}
+		addrs = append(addrs, l.Addr().String())
+		go func() {
+			<-iCtx.Done()
+			_ = l.Close()
+		}()
+
+		if len(addrs) == 1 {
+			cs.Restart()
+			if _, err = l.Accept(); err == nil {
+				t.Error("expected Accept to be interrupted")
+			}
+			// No iteration is accepting right now, but the socket must remain open and queue this for the next one
+			conn, dialErr := net.Dial("tcp", addrs[0])
+			if dialErr != nil {
+				t.Error("expected socket to remain open between iterations: ", dialErr)
+				return nil
+			}
+			return conn.Close()
+		}
+
+		conn, err := l.Accept()
+		if err != nil {
+			t.Error("expected the connection made between iterations: ", err)
+		} else {
+			_ = conn.Close()
+		}
+		cs.Stop()
+		<-iCtx.Done()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 2 || addrs[0] != addrs[1] {
+		t.Fatal("expected the same address for both iterations, got: ", addrs)
+	}
+	if _, err = net.Dial("tcp", addrs[0]); err == nil {
+		t.Error("expected socket to be closed once the ServiceManager is dead")
+	}
+	if _, err = sm.Listeners().Listen("tcp", "127.0.0.1:0"); err != ErrListenersClosed {
+		t.Error("expected ErrListenersClosed, got: ", err)
+	}
+}
+
+// acceptAsync accepts on l in the background
+func acceptAsync(l net.Listener) chan error {
+	done := make(chan error, 1)
+	go func() {
+		conn, err := l.Accept()
+		if conn != nil {
+			_ = conn.Close()
+		}
+		done <- err
+	}()
+	return done
+}
+
+// expectAccepted waits for the Accept started by acceptAsync to return
+func expectAccepted(t *testing.T, done chan error, closed bool) {
+	t.Helper()
+	select {
+	case err := <-done:
+		if closed && !errors.Is(err, net.ErrClosed) {
+			t.Error("expected net.ErrClosed, got: ", err)
+		}
+		if !closed && err != nil {
+			t.Error("expected a connection, got: ", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("expected Accept to return")
+	}
+}
+
+func TestListeners_CloseInterruptsOnlyItsView(t *testing.T) {
+	listeners := NewListeners()
+	defer listeners.Close()
+	first, err := listeners.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := listeners.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	firstDone := acceptAsync(first)
+	secondDone := acceptAsync(second)
+	time.Sleep(20 * time.Millisecond)
+
+	_ = first.Close()
+	expectAccepted(t, firstDone, true)
+	// The other view was not disturbed, and is still accepting
+	select {
+	case err = <-secondDone:
+		t.Fatal("expected the other view to keep accepting, got: ", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	conn, err := net.Dial("tcp", second.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	expectAccepted(t, secondDone, false)
+	if _, err = first.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Error("expected a closed view to stay closed, got: ", err)
+	}
+}
+
+func TestListeners_LateCloseFromOldIteration(t *testing.T) {
+	listeners := NewListeners()
+	defer listeners.Close()
+	old, err := listeners.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldDone := acceptAsync(old)
+	time.Sleep(20 * time.Millisecond)
+
+	// The next iteration starts accepting before the previous one got around to closing its view
+	current, err := listeners.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	currentDone := acceptAsync(current)
+	time.Sleep(20 * time.Millisecond)
+	_ = old.Close()
+	expectAccepted(t, oldDone, true)
+	// Closed again, long after, with nothing left to interrupt
+	_ = old.Close()
+	time.Sleep(20 * time.Millisecond)
+
+	conn, err := net.Dial("tcp", current.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	expectAccepted(t, currentDone, false)
+}
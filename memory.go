@@ -0,0 +1,189 @@
+package gracefully
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/metrics"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultMemoryInterval is how often MemoryPressure samples memory, if not configured
+	defaultMemoryInterval = 5 * time.Second
+	// defaultMemoryHysteresis is how far below a threshold memory must go before it can trigger again, if not configured
+	defaultMemoryHysteresis = 0.1
+	// defaultCgroupRoot is where cgroup v2 is usually mounted
+	defaultCgroupRoot = "/sys/fs/cgroup"
+	// defaultSoftRatio and defaultHardRatio are used when no threshold is configured at all
+	defaultSoftRatio = 0.85
+	defaultHardRatio = 0.95
+)
+
+// MemoryPressureOptions configures a MemoryPressure. Going above any soft threshold restarts the routine, going above any
+// hard threshold stops the ServiceManager. A threshold of 0 is not checked. If none are set, SoftRatio is 0.85 and
+// HardRatio is 0.95
+type MemoryPressureOptions struct {
+	// SoftLimit and HardLimit are thresholds on the memory the go runtime got from the operating system and did not
+	// release, in bytes, according to runtime/metrics
+	SoftLimit, HardLimit uint64
+	// SoftRatio and HardRatio are thresholds on memory.current as a fraction of memory.max of our cgroup, between 0 and 1.
+	// Not checked if there is no cgroup v2 or no memory limit
+	SoftRatio, HardRatio float64
+	// SoftPressure and HardPressure are thresholds on the share of time some tasks were stalled on memory over the last
+	// 10 seconds, from memory.pressure of our cgroup, in percent
+	SoftPressure, HardPressure float64
+	// Hysteresis is how far below a threshold, as a fraction of it, memory must go before going above it triggers again.
+	// Defaults to 0.1
+	Hysteresis float64
+	// Interval is how often memory is sampled. Defaults to 5 seconds
+	Interval time.Duration
+	// CgroupRoot is where cgroup v2 is mounted. Our cgroup is found under it using /proc/self/cgroup, otherwise, as
+	// within a cgroup namespace, it's CgroupRoot itself. Defaults to /sys/fs/cgroup
+	CgroupRoot string
+	// OnEvent, if set, is told when a threshold is crossed, and when memory went back down
+	OnEvent EventHandler
+}
+
+// MemoryPressure is a SignalSelecter that restarts the routine, or stops the ServiceManager, when memory runs low, so
+// that a slow leak is dealt with gracefully rather than by the OOM killer.
+// Do not instantiate yourself, call: NewMemoryPressure
+type MemoryPressure struct {
+	BaseSignaler
+	opts MemoryPressureOptions
+	// cgroup is the directory of our cgroup, it may not exist
+	cgroup string
+}
+
+// NewMemoryPressure creates a MemoryPressure, sampling memory from now on
+func NewMemoryPressure(opts MemoryPressureOptions) *MemoryPressure {
+	if opts.SoftLimit == 0 && opts.HardLimit == 0 && opts.SoftRatio == 0 && opts.HardRatio == 0 && opts.SoftPressure == 0 && opts.HardPressure == 0 {
+		opts.SoftRatio = defaultSoftRatio
+		opts.HardRatio = defaultHardRatio
+	}
+	if opts.Hysteresis <= 0 {
+		opts.Hysteresis = defaultMemoryHysteresis
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = defaultMemoryInterval
+	}
+	if opts.CgroupRoot == "" {
+		opts.CgroupRoot = defaultCgroupRoot
+	}
+	m := &MemoryPressure{
+		BaseSignaler: NewBaseSignaler(),
+		opts:         opts,
+		cgroup:       cgroupDir(opts.CgroupRoot),
+	}
+	watch := &thresholdWatch{
+		source:     "memory",
+		softAction: GracefulRestart,
+		hardAction: GracefulStop,
+		hysteresis: opts.Hysteresis,
+		interval:   opts.Interval,
+		sample:     m.sample,
+		onEvent:    opts.OnEvent,
+	}
+	go watch.run(&m.BaseSignaler)
+	return m
+}
+
+// Cancel stops sampling
+func (m *MemoryPressure) Cancel() {
+	m.cancel()
+}
+
+// sample reads the values there are thresholds for, those that can't be read are left out
+func (m *MemoryPressure) sample() []reading {
+	readings := make([]reading, 0, 3)
+	if m.opts.SoftLimit != 0 || m.opts.HardLimit != 0 {
+		readings = append(readings, reading{name: "runtime", value: float64(runtimeMemory()), soft: float64(m.opts.SoftLimit), hard: float64(m.opts.HardLimit)})
+	}
+	if m.opts.SoftRatio != 0 || m.opts.HardRatio != 0 {
+		current, errCurrent := readCgroupUint(filepath.Join(m.cgroup, "memory.current"))
+		limit, errLimit := readCgroupUint(filepath.Join(m.cgroup, "memory.max"))
+		if errCurrent == nil && errLimit == nil && limit > 0 {
+			readings = append(readings, reading{name: "cgroup", value: float64(current) / float64(limit), soft: m.opts.SoftRatio, hard: m.opts.HardRatio})
+		}
+	}
+	if m.opts.SoftPressure != 0 || m.opts.HardPressure != 0 {
+		if pressure, err := readMemoryPressure(filepath.Join(m.cgroup, "memory.pressure")); err == nil {
+			readings = append(readings, reading{name: "pressure", value: pressure, soft: m.opts.SoftPressure, hard: m.opts.HardPressure})
+		}
+	}
+	return readings
+}
+
+// runtimeMemory is the memory the go runtime got from the operating system and did not give back
+func runtimeMemory() uint64 {
+	samples := []metrics.Sample{
+		{Name: "/memory/classes/total:bytes"},
+		{Name: "/memory/classes/heap/released:bytes"},
+	}
+	metrics.Read(samples)
+	if samples[0].Value.Kind() != metrics.KindUint64 || samples[1].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return samples[0].Value.Uint64() - samples[1].Value.Uint64()
+}
+
+// cgroupDir is the directory of our cgroup under root
+func cgroupDir(root string) string {
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return root
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		// cgroup v2 is the line with hierarchy 0 and no controllers
+		if !strings.HasPrefix(line, "0::") {
+			continue
+		}
+		dir := filepath.Join(root, strings.TrimPrefix(line, "0::"))
+		if _, err := os.Stat(filepath.Join(dir, "memory.current")); err == nil {
+			return dir
+		}
+	}
+	return root
+}
+
+// readCgroupUint reads a cgroup file holding a single number, "max" is no limit, which is 0
+func readCgroupUint(path string) (uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	value := string(bytes.TrimSpace(data))
+	if value == "max" {
+		return 0, nil
+	}
+	return strconv.ParseUint(value, 10, 64)
+}
+
+// readMemoryPressure reads the "some avg10" value of a memory.pressure file
+func readMemoryPressure(path string) (float64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "some" {
+			continue
+		}
+		for _, field := range fields[1:] {
+			if strings.HasPrefix(field, "avg10=") {
+				return strconv.ParseFloat(strings.TrimPrefix(field, "avg10="), 64)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("no some avg10 in %s", path)
+}
@@ -0,0 +1,68 @@
+package gracefully
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeCgroup writes the memory files of a cgroup v2 into dir. They are replaced rather than rewritten, so that the
+// signaler never reads a half written file
+func fakeCgroup(t *testing.T, dir, current, max, pressure string) {
+	t.Helper()
+	for name, content := range map[string]string{"memory.current": current, "memory.max": max, "memory.pressure": pressure} {
+		tmp := filepath.Join(dir, "."+name)
+		if err := os.WriteFile(tmp, []byte(content+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmp, filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMemoryPressure_CgroupRatioWithHysteresis(t *testing.T) {
+	root := t.TempDir()
+	fakeCgroup(t, root, "500", "1000", "some avg10=0.00 avg60=0.00 avg300=0.00 total=0")
+	m := NewMemoryPressure(MemoryPressureOptions{CgroupRoot: root, Interval: 5 * time.Millisecond, SoftRatio: 0.8, HardRatio: 0.95})
+	defer m.Cancel()
+	expectNoAction(t, m, 20*time.Millisecond)
+
+	fakeCgroup(t, root, "850", "1000", "some avg10=0.00 avg60=0.00 avg300=0.00 total=0")
+	expectAction(t, m, GracefulRestart)
+	// Down, but not far enough below the threshold to trigger again
+	fakeCgroup(t, root, "750", "1000", "some avg10=0.00 avg60=0.00 avg300=0.00 total=0")
+	expectNoAction(t, m, 20*time.Millisecond)
+	fakeCgroup(t, root, "850", "1000", "some avg10=0.00 avg60=0.00 avg300=0.00 total=0")
+	expectNoAction(t, m, 20*time.Millisecond)
+	// Far enough below, then above again
+	fakeCgroup(t, root, "600", "1000", "some avg10=0.00 avg60=0.00 avg300=0.00 total=0")
+	time.Sleep(20 * time.Millisecond)
+	fakeCgroup(t, root, "850", "1000", "some avg10=0.00 avg60=0.00 avg300=0.00 total=0")
+	expectAction(t, m, GracefulRestart)
+
+	fakeCgroup(t, root, "990", "1000", "some avg10=0.00 avg60=0.00 avg300=0.00 total=0")
+	expectAction(t, m, GracefulStop)
+}
+
+func TestMemoryPressure_Pressure(t *testing.T) {
+	root := t.TempDir()
+	fakeCgroup(t, root, "500", "max", "some avg10=42.50 avg60=10.00 avg300=2.00 total=123\nfull avg10=1.00 avg60=0.00 avg300=0.00 total=1")
+	m := NewMemoryPressure(MemoryPressureOptions{CgroupRoot: root, Interval: 5 * time.Millisecond, SoftPressure: 20, HardPressure: 80})
+	defer m.Cancel()
+	expectAction(t, m, GracefulRestart)
+}
+
+func TestMemoryPressure_RuntimeLimit(t *testing.T) {
+	m := NewMemoryPressure(MemoryPressureOptions{CgroupRoot: t.TempDir(), Interval: 5 * time.Millisecond, SoftLimit: 1})
+	defer m.Cancel()
+	expectAction(t, m, GracefulRestart)
+}
+
+func TestMemoryPressure_NoCgroup(t *testing.T) {
+	// The default ratios, with nowhere to read them from
+	m := NewMemoryPressure(MemoryPressureOptions{CgroupRoot: t.TempDir(), Interval: 5 * time.Millisecond})
+	defer m.Cancel()
+	expectNoAction(t, m, 30*time.Millisecond)
+}
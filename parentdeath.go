@@ -0,0 +1,127 @@
+package gracefully
+
+import (
+	"os"
+	"os/signal"
+	"runtime"
+	"time"
+)
+
+// defaultParentPollInterval is how often ParentDeath checks its parent pid, if not configured
+const defaultParentPollInterval = time.Second
+
+// ParentDeathOptions configures a ParentDeath
+type ParentDeathOptions struct {
+	// Signal is what the kernel sends us when our parent dies, using PR_SET_PDEATHSIG. Defaults to SIGPWR on linux,
+	// elsewhere there is no such thing and only polling is used
+	Signal os.Signal
+	// PollInterval is how often the parent pid is checked for a change, in case the signal could not be used or never
+	// came. Defaults to 1 second
+	PollInterval time.Duration
+	// Parent is the pid of the process we must not outlive, such as handed to us by it in the environment, so that a parent
+	// that is gone before we get to look is noticed too. Defaults to our parent pid when created
+	Parent int
+	// OnEvent, if set, is told when PR_SET_PDEATHSIG could not be used
+	OnEvent EventHandler
+}
+
+// ParentDeath is a SignalSelecter that tells the ServiceManager what to do once the process that started us is gone,
+// for sidecars that must not outlive their parent.
+// Do not instantiate yourself, call: NewParentDeath or DefaultParentDeath
+type ParentDeath struct {
+	BaseSignaler
+	// action is what the ServiceManager should do once the parent is gone
+	action GracefulAction
+	// ppid is the parent we started with
+	ppid int
+	// getppid gets our current parent pid
+	getppid func() int
+}
+
+// NewParentDeath creates a ParentDeath that issues action once our parent is gone
+func NewParentDeath(action GracefulAction, opts ParentDeathOptions) *ParentDeath {
+	return newParentDeath(action, opts, os.Getppid)
+}
+
+// DefaultParentDeath creates a ParentDeath that stops the ServiceManager once our parent is gone
+func DefaultParentDeath() *ParentDeath {
+	return NewParentDeath(GracefulStop, ParentDeathOptions{})
+}
+
+// newParentDeath creates the ParentDeath, getppid is how the parent pid is found
+func newParentDeath(action GracefulAction, opts ParentDeathOptions, getppid func() int) *ParentDeath {
+	if opts.Signal == nil {
+		opts.Signal = defaultParentDeathSignal
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = defaultParentPollInterval
+	}
+	p := &ParentDeath{
+		BaseSignaler: NewBaseSignaler(),
+		action:       action,
+		ppid:         opts.Parent,
+		getppid:      getppid,
+	}
+	if p.ppid <= 0 {
+		p.ppid = getppid()
+	}
+
+	// The parent death signal belongs to the thread that asked for it, and can only be taken back from that thread, so
+	// the watching goroutine keeps its thread to itself
+	armed := make(chan error, 1)
+	go p.watch(opts.Signal, opts.PollInterval, armed)
+	if err := <-armed; err != nil && opts.OnEvent != nil {
+		opts.OnEvent(Event{Source: "parent-death", Signal: opts.Signal, Message: "unable to set the parent death signal, polling instead", Err: err})
+	}
+	return p
+}
+
+// watch waits for the parent to go away, until cancelled. Whether sig could be asked for is sent to armed
+func (p *ParentDeath) watch(sig os.Signal, pollInterval time.Duration, armed chan<- error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	deathSignal := make(chan os.Signal, 1)
+	var err error
+	if sig != nil {
+		// Listen before asking for the signal, otherwise it could kill us
+		signal.Notify(deathSignal, sig)
+		if err = setParentDeathSignal(sig); err != nil {
+			signal.Stop(deathSignal)
+		} else {
+			defer func() {
+				// From the thread that asked for it, and while still listening, without anyone listening it would terminate us
+				_ = setParentDeathSignal(nil)
+				signal.Stop(deathSignal)
+			}()
+		}
+	}
+	armed <- err
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	// Our parent can only die once. Checked before waiting, as the kernel only sends the signal for a parent dying once
+	// armed, one that is gone already is noticed right away
+	for !p.parentGone() {
+		select {
+		case <-deathSignal:
+			// The kernel sends it when the thread that started us exits, which is not always the whole parent. Polling
+			// tells them apart
+		case <-ticker.C:
+		case <-p.cancelled:
+			return
+		}
+	}
+	p.sendAction(p.action)
+	// Keeping the thread until cancelled, as only it can take the parent death signal back
+	<-p.cancelled
+}
+
+// Cancel stops watching the parent
+func (p *ParentDeath) Cancel() {
+	p.cancel()
+}
+
+// parentGone is true once we were re-parented, to init or a subreaper, which means the parent we started with is gone
+func (p *ParentDeath) parentGone() bool {
+	return p.getppid() != p.ppid
+}
@@ -0,0 +1,28 @@
+package gracefully
+
+import (
+	"os"
+	"syscall"
+)
+
+// prSetPdeathsig is PR_SET_PDEATHSIG from linux/prctl.h
+const prSetPdeathsig = 1
+
+// defaultParentDeathSignal is not used for anything else by default, by us or the go runtime
+var defaultParentDeathSignal os.Signal = syscall.SIGPWR
+
+// setParentDeathSignal asks the kernel to send us sig once our parent dies, nil to stop asking
+func setParentDeathSignal(sig os.Signal) error {
+	var sigNum uintptr
+	if sig != nil {
+		sysSig, ok := sig.(syscall.Signal)
+		if !ok {
+			return syscall.EINVAL
+		}
+		sigNum = uintptr(sysSig)
+	}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetPdeathsig, sigNum, 0); errno != 0 {
+		return os.NewSyscallError("prctl", errno)
+	}
+	return nil
+}
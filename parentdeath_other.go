@@ -0,0 +1,20 @@
+//go:build !linux
+// +build !linux
+
+package gracefully
+
+import (
+	"errors"
+	"os"
+)
+
+// defaultParentDeathSignal is nil, there is no parent death signal outside of linux
+var defaultParentDeathSignal os.Signal
+
+// setParentDeathSignal always fails outside of linux, unless there is nothing to set
+func setParentDeathSignal(sig os.Signal) error {
+	if sig == nil {
+		return nil
+	}
+	return errors.New("gracefully: parent death signal is only supported on linux")
+}
@@ -0,0 +1,61 @@
+package gracefully
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParentDeath_StopsWhenReparented(t *testing.T) {
+	var ppid int32 = 42
+	p := newParentDeath(GracefulStop, ParentDeathOptions{PollInterval: time.Millisecond}, func() int {
+		return int(atomic.LoadInt32(&ppid))
+	})
+	defer p.Cancel()
+	select {
+	case <-p.Select():
+		t.Fatal("parent is still there")
+	case <-time.After(20 * time.Millisecond):
+	}
+	// Our parent died, init adopted us
+	atomic.StoreInt32(&ppid, 1)
+	select {
+	case control := <-p.Select():
+		if action := control(nil); action != GracefulStop {
+			t.Error("expected GracefulStop, got: ", action)
+		}
+	case <-time.After(time.Second):
+		t.Error("expected the parent's death to be noticed")
+	}
+}
+
+func TestParentDeath_ParentGoneBeforeArmed(t *testing.T) {
+	// Started by 42, which was gone by the time we looked
+	p := newParentDeath(GracefulStop, ParentDeathOptions{Parent: 42, PollInterval: time.Hour}, func() int {
+		return 1
+	})
+	defer p.Cancel()
+	expectAction(t, p, GracefulStop)
+}
+
+func TestParentDeath_CancelStopsPolling(t *testing.T) {
+	var ppid int32 = 42
+	var polls int32
+	p := newParentDeath(GracefulRestart, ParentDeathOptions{PollInterval: time.Millisecond}, func() int {
+		atomic.AddInt32(&polls, 1)
+		return int(atomic.LoadInt32(&ppid))
+	})
+	p.Cancel()
+	time.Sleep(10 * time.Millisecond)
+	before := atomic.LoadInt32(&polls)
+	atomic.StoreInt32(&ppid, 1)
+	time.Sleep(10 * time.Millisecond)
+	if after := atomic.LoadInt32(&polls); after != before {
+		t.Error("expected polling to stop once cancelled, polled: ", after-before)
+	}
+	select {
+	case <-p.Select():
+		t.Error("expected nothing once cancelled")
+	default:
+	}
+}
@@ -0,0 +1,286 @@
+package gracefully
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrPoolClosed is returned by Pool.Submit once the pool was stopped and no longer accepts jobs
+var ErrPoolClosed = errors.New("gracefully: pool is closed")
+
+// PoolOptions configures a Pool
+type PoolOptions struct {
+	// Workers is how many jobs are handled at the same time. Defaults to 1
+	Workers int
+	// QueueSize is how many jobs can wait for a worker before Submit blocks. Defaults to Workers
+	QueueSize int
+	// DrainTimeout is how long the workers get to empty the queue when stopping, or to finish their current job when restarting.
+	// Once over, the context handed to Handler is cancelled. Defaults to 30 seconds
+	DrainTimeout time.Duration
+	// Handler does the work for a single job. ctx is cancelled if the job is still running after DrainTimeout
+	Handler func(ctx context.Context, job interface{})
+	// Spool, if set, is where leftover jobs are written when the pool stops. The jobs in it are replayed into the queue
+	// when the pool first runs, Submit blocks until that's done. They are only removed from it once the pool stopped,
+	// so they're replayed again if the process crashes before that
+	Spool *Spool
+	// OnEvent, if set, is told when the Spool could not be fully replayed. The pool keeps running with what could be
+	OnEvent EventHandler
+}
+
+// Pool is a fixed number of workers taking jobs from a bounded queue, run as the routine of a ServiceManager.
+// The queue belongs to the Pool, not the iteration, so jobs survive a GracefulRestart, which is also when a
+// Resize takes effect. On GracefulStop, Submit is refused and the workers empty the queue until DrainTimeout,
+// whatever is still queued after that is available from Leftover.
+//
+// Do not instantiate yourself, call: NewPool
+type Pool struct {
+	opts PoolOptions
+	// queue holds the jobs waiting for a worker
+	queue chan interface{}
+	// closing is closed when intake stops, to wake up Submit calls waiting for room in the queue
+	closing chan struct{}
+	// intakeMu is held for reading by Submit while it may send to queue, and for writing to stop the intake
+	intakeMu sync.RWMutex
+	// closed is true once intake stopped, protected by intakeMu
+	closed bool
+	// replayed is closed once the jobs in the Spool are back in the queue
+	replayed chan struct{}
+	// mu protects all fields below
+	mu sync.Mutex
+	// workers is how many workers the next iteration will start
+	workers int
+	// leftover are the jobs that were still queued when the pool stopped
+	leftover []interface{}
+}
+
+// NewPool creates a new Pool, ready to be passed to ServiceManager.Start or Run as pool.Run
+func NewPool(opts PoolOptions) *Pool {
+	if opts.Workers <= 0 {
+		opts.Workers = 1
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = opts.Workers
+	}
+	if opts.DrainTimeout <= 0 {
+		opts.DrainTimeout = defaultShutdownTimeout
+	}
+	p := &Pool{
+		opts:     opts,
+		queue:    make(chan interface{}, opts.QueueSize),
+		closing:  make(chan struct{}),
+		replayed: make(chan struct{}),
+		workers:  opts.Workers,
+	}
+	if opts.Spool == nil {
+		close(p.replayed)
+	}
+	return p
+}
+
+// Submit queues a job for the workers, blocking until there is room in the queue or ctx is done.
+// Once the pool has stopped, ErrPoolClosed is returned
+func (p *Pool) Submit(ctx context.Context, job interface{}) error {
+	p.intakeMu.RLock()
+	defer p.intakeMu.RUnlock()
+	if p.closed {
+		return ErrPoolClosed
+	}
+	// Jobs from the spool go first
+	select {
+	case <-p.replayed:
+	case <-p.closing:
+		return ErrPoolClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case p.queue <- job:
+		return nil
+	case <-p.closing:
+		return ErrPoolClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Resize changes how many workers are running. It takes effect the next time the pool is (re)started, queued jobs are kept
+func (p *Pool) Resize(workers int) {
+	if workers <= 0 {
+		workers = 1
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.workers = workers
+}
+
+// Leftover gets the jobs that were still queued when the pool stopped because DrainTimeout was reached, so they can be persisted.
+// If a Spool is configured, this is only set if writing the jobs to it failed
+func (p *Pool) Leftover() []interface{} {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.leftover
+}
+
+// Run is the routine to pass to ServiceManager.Start or Run. It starts the workers and keeps them running until ctx is done.
+// If the ServiceManager is restarting, the workers finish the job they are on and the queue is left for the next iteration,
+// otherwise the pool stops taking jobs and the queue is drained
+func (p *Pool) Run(ctx context.Context) error {
+	p.mu.Lock()
+	workers := p.workers
+	p.mu.Unlock()
+
+	// jobCtx outlives ctx so that jobs can finish while draining
+	jobCtx, cancelJobs := context.WithCancel(context.Background())
+	defer cancelJobs()
+	// stopTaking tells workers to stop taking jobs from the queue, after the current one
+	stopTaking := make(chan struct{})
+	// draining tells workers to empty the queue, then stop
+	draining := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			p.work(jobCtx, stopTaking, draining)
+		}()
+	}
+
+	p.replay(ctx)
+	<-ctx.Done()
+	// Paused or restarting, the next iteration carries on with the queue
+	restarting := iterationComesBack(ctx)
+	if restarting {
+		close(stopTaking)
+	} else {
+		p.closeIntake()
+		close(draining)
+	}
+
+	// Give the workers until the deadline, then cancel what they are doing
+	workersDone := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(workersDone)
+	}()
+	timer := time.NewTimer(p.opts.DrainTimeout)
+	defer timer.Stop()
+	select {
+	case <-workersDone:
+	case <-timer.C:
+		cancelJobs()
+		<-workersDone
+	}
+
+	if !restarting {
+		p.collectLeftover()
+		return p.spoolLeftover()
+	}
+	return nil
+}
+
+// replay puts the jobs in the spool, if any, back in the queue, ahead of the ones from Submit. If ctx is done first,
+// the remaining jobs stay in the spool for the next iteration. A spool that can't be fully read is reported, the jobs
+// that could be are queued and new ones accepted all the same
+func (p *Pool) replay(ctx context.Context) {
+	select {
+	case <-p.replayed:
+		return
+	default:
+	}
+	err := p.opts.Spool.Replay(func(job interface{}) error {
+		select {
+		case p.queue <- job:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	if ctx.Err() != nil {
+		return
+	}
+	close(p.replayed)
+	if err != nil && p.opts.OnEvent != nil {
+		p.opts.OnEvent(Event{Source: "pool", Message: "unable to replay the spool", Err: err})
+	}
+}
+
+// spoolLeftover replaces the jobs replayed from the spool with the leftover jobs, in the order they were queued
+func (p *Pool) spoolLeftover() error {
+	if p.opts.Spool == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.opts.Spool.Replace(p.leftover); err != nil {
+		return err
+	}
+	p.leftover = nil
+	return nil
+}
+
+// work takes jobs from the queue until told to stop
+func (p *Pool) work(ctx context.Context, stopTaking, draining <-chan struct{}) {
+	for {
+		// Being told to stop takes priority over taking another job from the queue
+		select {
+		case <-stopTaking:
+			return
+		case <-draining:
+			p.drainQueue(ctx)
+			return
+		default:
+		}
+		select {
+		case <-stopTaking:
+			return
+		case <-draining:
+			p.drainQueue(ctx)
+			return
+		case job := <-p.queue:
+			p.opts.Handler(ctx, job)
+		}
+	}
+}
+
+// drainQueue handles jobs until the queue is empty or ctx is done
+func (p *Pool) drainQueue(ctx context.Context) {
+	for ctx.Err() == nil {
+		select {
+		case job := <-p.queue:
+			p.opts.Handler(ctx, job)
+		default:
+			return
+		}
+	}
+}
+
+// closeIntake stops Submit from queueing any more jobs
+func (p *Pool) closeIntake() {
+	p.intakeMu.RLock()
+	closed := p.closed
+	p.intakeMu.RUnlock()
+	if closed {
+		return
+	}
+	// Wake up the Submits waiting for room first, otherwise we'd wait on them forever
+	close(p.closing)
+	p.intakeMu.Lock()
+	p.closed = true
+	p.intakeMu.Unlock()
+}
+
+// collectLeftover takes whatever is still in the queue
+func (p *Pool) collectLeftover() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for {
+		select {
+		case job := <-p.queue:
+			p.leftover = append(p.leftover, job)
+		default:
+			return
+		}
+	}
+}
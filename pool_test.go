@@ -0,0 +1,139 @@
+package gracefully
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPool_LeftoverAfterDrainTimeout(t *testing.T) {
+	started := make(chan bool, 1)
+	pool := NewPool(PoolOptions{
+		QueueSize:    3,
+		DrainTimeout: time.Second / 20,
+		Handler: func(ctx context.Context, job interface{}) {
+			started <- true
+			// Stuck until the drain timeout cancels us
+			<-ctx.Done()
+		},
+	})
+	for i := 0; i < 3; i++ {
+		if err := pool.Submit(context.Background(), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sm := New()
+	cs := NewContextSignal()
+	sm.AddSignaler(cs)
+	go func() {
+		<-started
+		cs.Stop()
+	}()
+	if err := sm.Run(pool.Run); err != nil {
+		t.Fatal(err)
+	}
+	leftover := pool.Leftover()
+	if len(leftover) != 2 || leftover[0] != 1 || leftover[1] != 2 {
+		t.Error("expected jobs 1 and 2 to be left over, got: ", leftover)
+	}
+	if err := pool.Submit(context.Background(), 4); err != ErrPoolClosed {
+		t.Error("expected ErrPoolClosed, got: ", err)
+	}
+}
+
+func TestPool_RestartKeepsQueueAndResizes(t *testing.T) {
+	var mu sync.Mutex
+	handled := make([]interface{}, 0, 4)
+	allHandled := make(chan bool)
+	pool := NewPool(PoolOptions{
+		Workers:   1,
+		QueueSize: 4,
+		Handler: func(ctx context.Context, job interface{}) {
+			mu.Lock()
+			defer mu.Unlock()
+			handled = append(handled, job)
+			if len(handled) == 4 {
+				close(allHandled)
+			}
+		},
+	})
+	sm := New()
+	cs := NewContextSignal()
+	sm.AddSignaler(cs)
+	iterations := 0
+	err := sm.Run(func(iCtx context.Context) error {
+		iterations++
+		if iterations == 1 {
+			pool.Resize(2)
+			cs.Restart()
+		} else {
+			go func() {
+				for i := 0; i < 4; i++ {
+					_ = pool.Submit(context.Background(), i)
+				}
+				<-allHandled
+				cs.Stop()
+			}()
+		}
+		return pool.Run(iCtx)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pool.Leftover()) != 0 {
+		t.Error("expected no leftover, got: ", pool.Leftover())
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(handled) != 4 {
+		t.Error("expected all jobs to be handled, got: ", handled)
+	}
+}
+
+func TestPool_PauseKeepsIntakeOpen(t *testing.T) {
+	handled := make(chan interface{}, 1)
+	pool := NewPool(PoolOptions{
+		Workers:   1,
+		QueueSize: 1,
+		Handler: func(ctx context.Context, job interface{}) {
+			handled <- job
+		},
+	})
+	sm := New()
+	cs := NewContextSignal()
+	sm.AddSignaler(cs)
+	iterations := 0
+	err := sm.Run(func(iCtx context.Context) error {
+		iterations++
+		if iterations == 1 {
+			cs.Pause()
+			go func() {
+				for sm.State() != StatePaused {
+					time.Sleep(time.Millisecond)
+				}
+				cs.Resume()
+			}()
+		} else {
+			go func() {
+				defer cs.Stop()
+				if err := pool.Submit(context.Background(), "resumed"); err != nil {
+					t.Error("expected the pool to take jobs once resumed, got: ", err)
+					return
+				}
+				select {
+				case <-handled:
+				case <-time.After(5 * time.Second):
+					t.Error("expected the job to be handled once resumed")
+				}
+			}()
+		}
+		return pool.Run(iCtx)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if iterations != 2 {
+		t.Error("expected 2 iterations, got: ", iterations)
+	}
+}
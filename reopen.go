@@ -0,0 +1,156 @@
+//go:build !windows
+// +build !windows
+
+package gracefully
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// ReopenableFileOptions configures a ReopenableFile
+type ReopenableFileOptions struct {
+	// Signal is what makes the file reopen, such as from a logrotate postrotate script. Defaults to SIGUSR1
+	Signal os.Signal
+	// Mode is the permissions of the file, if it's created. Defaults to 0644
+	Mode os.FileMode
+	// OnEvent, if set, is told when the file could not be reopened, in which case writes keep going to the file that was
+	// open until then, and when it could not be closed
+	OnEvent EventHandler
+}
+
+// ReopenableFile is an io.Writer appending to a file that is opened again on a signal, so that log files can be rotated
+// by moving them away, rather than with copytruncate, which loses what is written while copying. It's safe for
+// concurrent writes.
+// It's also a SignalSelecter, add it to the ServiceManager to have the signal handled by Wait, without restarting the
+// routine, and to have the file synced and closed once the ServiceManager reaches StateDead.
+// Do not instantiate yourself, call: NewReopenableFile
+type ReopenableFile struct {
+	BaseSignaler
+	path string
+	opts ReopenableFileOptions
+	// signalChan is where to put incoming signals from the OS
+	signalChan chan os.Signal
+	// mu protects file
+	mu sync.Mutex
+	// file is what is written to, nil once closed
+	file *os.File
+}
+
+// NewReopenableFile opens the file at path for appending, creating it if needed
+func NewReopenableFile(path string, opts ReopenableFileOptions) (*ReopenableFile, error) {
+	if opts.Signal == nil {
+		opts.Signal = syscall.SIGUSR1
+	}
+	if opts.Mode == 0 {
+		opts.Mode = 0644
+	}
+	r := &ReopenableFile{
+		BaseSignaler: NewBaseSignaler(),
+		path:         path,
+		opts:         opts,
+		// opting for size 1, a reopen is as good as several
+		signalChan: make(chan os.Signal, 1),
+	}
+	var err error
+	if r.file, err = r.open(); err != nil {
+		return nil, err
+	}
+	signal.Notify(r.signalChan, opts.Signal)
+	go r.run()
+	return r, nil
+}
+
+// Write appends p to the file
+func (r *ReopenableFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return 0, os.ErrClosed
+	}
+	return r.file.Write(p)
+}
+
+// Reopen opens the file at path again, and writes go there from now on. Writes are never lost in between: the new file
+// is opened before the old one is closed. If the file can't be opened, writes keep going to the old one
+func (r *ReopenableFile) Reopen() error {
+	file, err := r.open()
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	old := r.file
+	if old == nil {
+		r.mu.Unlock()
+		_ = file.Close()
+		return os.ErrClosed
+	}
+	r.file = file
+	r.mu.Unlock()
+	return old.Close()
+}
+
+// Close stops listening for the signal, flushes the file to disk and closes it. Writes fail with os.ErrClosed from now on
+func (r *ReopenableFile) Close() error {
+	r.Cancel()
+	r.mu.Lock()
+	file := r.file
+	r.file = nil
+	r.mu.Unlock()
+	if file == nil {
+		return nil
+	}
+	err := file.Sync()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Cancel stops listening for the signal, the file is still written to until closed
+func (r *ReopenableFile) Cancel() {
+	if r.cancel() {
+		signal.Stop(r.signalChan)
+	}
+}
+
+// managerDead closes the file once the ServiceManager is done
+func (r *ReopenableFile) managerDead() {
+	if err := r.Close(); err != nil {
+		r.emit(Event{Source: "reopenable-file", Message: "unable to close " + r.path, Err: err})
+	}
+}
+
+// run has the ServiceManager reopen the file on every signal, until cancelled
+func (r *ReopenableFile) run() {
+	for {
+		select {
+		case sig := <-r.signalChan:
+			sent := r.send(func(manager *ServiceManager) GracefulAction {
+				if err := r.Reopen(); err != nil {
+					r.emit(Event{Source: "reopenable-file", Signal: sig, Message: "unable to reopen " + r.path, Err: err})
+				}
+				return GracefulNone
+			})
+			if !sent {
+				return
+			}
+		case <-r.cancelled:
+			return
+		}
+	}
+}
+
+// open opens the file at path for appending
+func (r *ReopenableFile) open() (*os.File, error) {
+	return os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, r.opts.Mode)
+}
+
+// emit reports the event, if anyone is listening
+func (r *ReopenableFile) emit(e Event) {
+	if r.opts.OnEvent != nil {
+		r.opts.OnEvent(e)
+	}
+}
@@ -0,0 +1,132 @@
+//go:build !windows
+// +build !windows
+
+package gracefully
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestReopenableFile_RotateWithoutLosingLines(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	f, err := NewReopenableFile(path, ReopenableFileOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm := New()
+	sm.AddSignaler(f)
+	cs := NewContextSignal()
+	sm.AddSignaler(cs)
+	iterations := 0
+	sm.Start(func(iCtx context.Context) error {
+		iterations++
+		<-iCtx.Done()
+		return nil
+	})
+	done := make(chan error, 1)
+	go func() {
+		done <- sm.Wait()
+	}()
+
+	// Keep writing while the file is rotated
+	stopWriting := make(chan struct{})
+	written := make(chan int, 1)
+	go func() {
+		n := 0
+		for {
+			select {
+			case <-stopWriting:
+				written <- n
+				return
+			default:
+			}
+			if _, err := f.Write([]byte("line\n")); err != nil {
+				t.Error("unexpected write error: ", err)
+			}
+			n++
+		}
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+	// The new file exists as soon as it's opened, but only gets written to once swapped in
+	deadline := time.Now().Add(5 * time.Second)
+	for size(path) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the file to be reopened")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(stopWriting)
+	n := <-written
+
+	cs.Stop()
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+	if iterations != 1 {
+		t.Error("expected the routine to run once, got: ", iterations)
+	}
+	if _, err := f.Write([]byte("late\n")); !errors.Is(err, os.ErrClosed) {
+		t.Error("expected the file to be closed with the ServiceManager, got: ", err)
+	}
+
+	rotated, _ := os.ReadFile(path + ".1")
+	current, _ := os.ReadFile(path)
+	if len(current) == 0 {
+		t.Error("expected writes to go to the reopened file")
+	}
+	if lines := bytes.Count(rotated, []byte("\n")) + bytes.Count(current, []byte("\n")); lines != n {
+		t.Error("expected ", n, " lines, got: ", lines)
+	}
+}
+
+func TestReopenableFile_ReopenFailureKeepsOldFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "logs", "app.log")
+	if err := os.Mkdir(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := NewReopenableFile(path, ReopenableFileOptions{Signal: syscall.SIGUSR2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := os.Rename(filepath.Dir(path), filepath.Join(dir, "moved")); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Reopen(); err == nil {
+		t.Error("expected reopen to fail")
+	}
+	if _, err := f.Write([]byte("still here\n")); err != nil {
+		t.Error("expected writes to keep going to the old file, got: ", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Error(err)
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, "moved", "app.log"))
+	if string(data) != "still here\n" {
+		t.Error("expected the write in the old file, got: ", string(data))
+	}
+}
+
+// size is how many bytes the file at path holds, 0 if it does not exist
+func size(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
@@ -0,0 +1,112 @@
+package gracefully
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// reading is one of the values sampled by a signaler watching a resource, with its thresholds, 0 is not checked
+type reading struct {
+	name       string
+	value      float64
+	soft, hard float64
+	// below is true if going under the thresholds is the problem, such as with free space
+	below bool
+}
+
+// thresholdWatch samples readings until cancelled, and tells the ServiceManager to take softAction or hardAction when any
+// of them crosses its soft or hard threshold.
+// A threshold is armed until it triggers, then again once every reading went back by more than hysteresis, as a fraction
+// of the threshold, so that hovering around it does not trigger over and over again
+type thresholdWatch struct {
+	source                 string
+	softAction, hardAction GracefulAction
+	hysteresis             float64
+	interval               time.Duration
+	sample                 func() []reading
+	onEvent                EventHandler
+}
+
+// run samples until cancelled
+func (w *thresholdWatch) run(signaler *BaseSignaler) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	softArmed, hardArmed := true, true
+	for {
+		readings := w.sample()
+		var action GracefulAction
+		fire := true
+		switch {
+		case hardArmed && crossed(readings, hardThreshold):
+			// Whatever the soft threshold would have done is moot
+			hardArmed = false
+			softArmed = softArmed && !crossed(readings, softThreshold)
+			action = w.hardAction
+			w.emit("hard threshold crossed", readings)
+		case softArmed && crossed(readings, softThreshold):
+			softArmed = false
+			action = w.softAction
+			w.emit("soft threshold crossed", readings)
+		default:
+			fire = false
+		}
+		if fire {
+			if !signaler.sendAction(action) {
+				return
+			}
+		}
+		if !hardArmed && cleared(readings, hardThreshold, w.hysteresis) {
+			hardArmed = true
+			w.emit("recovered from the hard threshold", readings)
+		}
+		if !softArmed && cleared(readings, softThreshold, w.hysteresis) {
+			softArmed = true
+			w.emit("recovered from the soft threshold", readings)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-signaler.cancelled:
+			return
+		}
+	}
+}
+
+// emit reports what happened with the readings, if anyone is listening
+func (w *thresholdWatch) emit(message string, readings []reading) {
+	if w.onEvent == nil {
+		return
+	}
+	values := make([]string, 0, len(readings))
+	for _, r := range readings {
+		values = append(values, fmt.Sprintf("%s=%g", r.name, r.value))
+	}
+	w.onEvent(Event{Source: w.source, Message: message + ": " + strings.Join(values, " ")})
+}
+
+// softThreshold and hardThreshold pick a threshold of a reading
+func softThreshold(r reading) float64 { return r.soft }
+func hardThreshold(r reading) float64 { return r.hard }
+
+// crossed is true if any reading is at or past its threshold
+func crossed(readings []reading, threshold func(reading) float64) bool {
+	for _, r := range readings {
+		t := threshold(r)
+		if t != 0 && (r.value >= t && !r.below || r.value <= t && r.below) {
+			return true
+		}
+	}
+	return false
+}
+
+// cleared is true if every reading is far enough back from its threshold
+func cleared(readings []reading, threshold func(reading) float64, hysteresis float64) bool {
+	for _, r := range readings {
+		t := threshold(r)
+		if t != 0 && (r.value >= t*(1-hysteresis) && !r.below || r.value <= t*(1+hysteresis) && r.below) {
+			return false
+		}
+	}
+	return true
+}
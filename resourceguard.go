@@ -0,0 +1,208 @@
+//go:build !windows
+// +build !windows
+
+package gracefully
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+const (
+	// defaultResourceInterval is how often a ResourceGuard samples, if not configured
+	defaultResourceInterval = 10 * time.Second
+	// defaultResourceHysteresis is how far back from a threshold a resource must go before it can trigger again, if not configured
+	defaultResourceHysteresis = 0.1
+	// defaultMinFreeRatio is the free space below which a disk is full, if nothing is configured
+	defaultMinFreeRatio = 0.05
+	// defaultMaxFDRatio is the share of RLIMIT_NOFILE above which we're running out of file descriptors, if nothing is configured
+	defaultMaxFDRatio = 0.9
+	// defaultProcRoot is where procfs is usually mounted
+	defaultProcRoot = "/proc"
+)
+
+// DiskSpaceOptions configures a ResourceGuard watching free space. If neither MinFree nor MinFreeRatio are set,
+// MinFreeRatio is 0.05
+type DiskSpaceOptions struct {
+	// Paths are files or directories on the filesystems to watch, such as the directory logs go to
+	Paths []string
+	// MinFree is the space available to us, in bytes, below which the filesystem is full. 0 is not checked
+	MinFree uint64
+	// MinFreeRatio is the space available to us, as a fraction of the size of the filesystem, below which it's full. 0 is not checked
+	MinFreeRatio float64
+	// Action is what the ServiceManager should do once a filesystem is full. Left unset, it's GracefulRestart, the zero
+	// value, which lets go of files that were deleted but kept open. Use GracefulStop to drain and stop before state
+	// gets corrupted by failing writes
+	Action GracefulAction
+	// Hysteresis is how far above a threshold, as a fraction of it, free space must go before going below it triggers
+	// again. Defaults to 0.1
+	Hysteresis float64
+	// Interval is how often free space is checked. Defaults to 10 seconds
+	Interval time.Duration
+	// Statfs gets the space available to us, and the size, of the filesystem a path is on. Defaults to asking the kernel
+	Statfs StatfsFunc
+	// OnEvent, if set, is told when a filesystem is full, when it's not anymore, and when it can't be checked
+	OnEvent EventHandler
+}
+
+// FDOptions configures a ResourceGuard watching the file descriptors we have open. If neither MaxOpen nor MaxRatio are
+// set, MaxRatio is 0.9
+type FDOptions struct {
+	// MaxOpen is the number of open file descriptors above which we're running out. 0 is not checked
+	MaxOpen int
+	// MaxRatio is the number of open file descriptors, as a fraction of RLIMIT_NOFILE, above which we're running out. 0 is not checked
+	MaxRatio float64
+	// Action is what the ServiceManager should do once we're running out. Left unset, it's GracefulRestart, the zero value,
+	// which lets go of whatever the routine leaked
+	Action GracefulAction
+	// Hysteresis is how far below a threshold, as a fraction of it, the count must go before going above it triggers
+	// again. Defaults to 0.1
+	Hysteresis float64
+	// Interval is how often file descriptors are counted. Defaults to 10 seconds
+	Interval time.Duration
+	// ProcRoot is where procfs is mounted, they're counted in ProcRoot/self/fd. Defaults to /proc
+	ProcRoot string
+	// OnEvent, if set, is told when we're running out, when we're not anymore, and when they can't be counted
+	OnEvent EventHandler
+}
+
+// ResourceGuard is a SignalSelecter that tells the ServiceManager what to do when a resource, such as disk space or file
+// descriptors, runs out, and reports when it recovers.
+// Do not instantiate yourself, call: NewDiskSpaceGuard or NewFDGuard
+type ResourceGuard struct {
+	BaseSignaler
+}
+
+// StatfsFunc gets the space available to us, and the size, of the filesystem path is on
+type StatfsFunc func(path string) (free, total uint64, err error)
+
+// NewDiskSpaceGuard creates a ResourceGuard checking the free space of the filesystems opts.Paths are on
+func NewDiskSpaceGuard(opts DiskSpaceOptions) *ResourceGuard {
+	if opts.MinFree == 0 && opts.MinFreeRatio == 0 {
+		opts.MinFreeRatio = defaultMinFreeRatio
+	}
+	if opts.Statfs == nil {
+		opts.Statfs = statfs
+	}
+	g := newResourceGuard()
+	// failing is the paths that could not be checked last time, so that it's only reported once
+	failing := make(map[string]bool)
+	watch := &thresholdWatch{
+		source:     "disk-space",
+		hardAction: opts.Action,
+		hysteresis: opts.Hysteresis,
+		interval:   opts.Interval,
+		onEvent:    opts.OnEvent,
+		sample: func() []reading {
+			readings := make([]reading, 0, 2*len(opts.Paths))
+			for _, path := range opts.Paths {
+				free, total, err := opts.Statfs(path)
+				if err != nil {
+					if !failing[path] && opts.OnEvent != nil {
+						opts.OnEvent(Event{Source: "disk-space", Message: "unable to check " + path, Err: err})
+					}
+					failing[path] = true
+					continue
+				}
+				delete(failing, path)
+				if opts.MinFree != 0 {
+					readings = append(readings, reading{name: path, value: float64(free), hard: float64(opts.MinFree), below: true})
+				}
+				if opts.MinFreeRatio != 0 && total > 0 {
+					readings = append(readings, reading{name: path + " ratio", value: float64(free) / float64(total), hard: opts.MinFreeRatio, below: true})
+				}
+			}
+			return readings
+		},
+	}
+	g.start(watch)
+	return g
+}
+
+// NewFDGuard creates a ResourceGuard counting the file descriptors we have open
+func NewFDGuard(opts FDOptions) *ResourceGuard {
+	if opts.MaxOpen == 0 && opts.MaxRatio == 0 {
+		opts.MaxRatio = defaultMaxFDRatio
+	}
+	if opts.ProcRoot == "" {
+		opts.ProcRoot = defaultProcRoot
+	}
+	g := newResourceGuard()
+	dir := filepath.Join(opts.ProcRoot, "self", "fd")
+	failing := false
+	watch := &thresholdWatch{
+		source:     "fd",
+		hardAction: opts.Action,
+		hysteresis: opts.Hysteresis,
+		interval:   opts.Interval,
+		onEvent:    opts.OnEvent,
+		sample: func() []reading {
+			count, err := countEntries(dir)
+			if err != nil {
+				if !failing && opts.OnEvent != nil {
+					opts.OnEvent(Event{Source: "fd", Message: "unable to count file descriptors in " + dir, Err: err})
+				}
+				failing = true
+				return nil
+			}
+			failing = false
+			readings := make([]reading, 0, 2)
+			if opts.MaxOpen != 0 {
+				readings = append(readings, reading{name: "open", value: float64(count), hard: float64(opts.MaxOpen)})
+			}
+			// Looked up every time, as it can be raised while we run
+			var limit syscall.Rlimit
+			if opts.MaxRatio != 0 && syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit) == nil && limit.Cur > 0 {
+				readings = append(readings, reading{name: "open ratio", value: float64(count) / float64(limit.Cur), hard: opts.MaxRatio})
+			}
+			return readings
+		},
+	}
+	g.start(watch)
+	return g
+}
+
+// newResourceGuard creates a ResourceGuard that is not sampling yet
+func newResourceGuard() *ResourceGuard {
+	return &ResourceGuard{
+		BaseSignaler: NewBaseSignaler(),
+	}
+}
+
+// start samples with watch, with the defaults for anything it's missing
+func (g *ResourceGuard) start(watch *thresholdWatch) {
+	if watch.hysteresis <= 0 {
+		watch.hysteresis = defaultResourceHysteresis
+	}
+	if watch.interval <= 0 {
+		watch.interval = defaultResourceInterval
+	}
+	go watch.run(&g.BaseSignaler)
+}
+
+// Cancel stops sampling
+func (g *ResourceGuard) Cancel() {
+	g.cancel()
+}
+
+// statfs gets the space available to unprivileged users, and the size, of the filesystem path is on
+func statfs(path string) (free, total uint64, err error) {
+	var st syscall.Statfs_t
+	if err = syscall.Statfs(path, &st); err != nil {
+		return 0, 0, &os.PathError{Op: "statfs", Path: path, Err: err}
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), uint64(st.Blocks) * uint64(st.Bsize), nil
+}
+
+// countEntries counts what is in dir
+func countEntries(dir string) (int, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	names, err := f.Readdirnames(-1)
+	return len(names), err
+}
@@ -0,0 +1,99 @@
+//go:build !windows
+// +build !windows
+
+package gracefully
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDiskSpaceGuard_FullThenRecovered(t *testing.T) {
+	var free uint64 = 500
+	events := make(chan Event, 10)
+	g := NewDiskSpaceGuard(DiskSpaceOptions{
+		Paths:    []string{"/data"},
+		MinFree:  100,
+		Action:   GracefulStop,
+		Interval: 5 * time.Millisecond,
+		Statfs: func(path string) (uint64, uint64, error) {
+			return atomic.LoadUint64(&free), 1000, nil
+		},
+		OnEvent: func(e Event) { events <- e },
+	})
+	defer g.Cancel()
+	expectNoAction(t, g, 20*time.Millisecond)
+
+	atomic.StoreUint64(&free, 50)
+	expectAction(t, g, GracefulStop)
+	// Just above the threshold is not enough to recover
+	atomic.StoreUint64(&free, 105)
+	expectNoAction(t, g, 20*time.Millisecond)
+	atomic.StoreUint64(&free, 400)
+	deadline := time.After(time.Second)
+	for recovered := false; !recovered; {
+		select {
+		case e := <-events:
+			recovered = strings.HasPrefix(e.Message, "recovered")
+		case <-deadline:
+			t.Fatal("expected a recovery event")
+		}
+	}
+	atomic.StoreUint64(&free, 50)
+	expectAction(t, g, GracefulStop)
+}
+
+func TestDiskSpaceGuard_Real(t *testing.T) {
+	g := NewDiskSpaceGuard(DiskSpaceOptions{Paths: []string{t.TempDir()}, MinFree: 1 << 62, Action: GracefulPause, Interval: 5 * time.Millisecond})
+	defer g.Cancel()
+	expectAction(t, g, GracefulPause)
+}
+
+func TestDiskSpaceGuard_DefaultsToRestart(t *testing.T) {
+	g := NewDiskSpaceGuard(DiskSpaceOptions{
+		Paths:    []string{"/data"},
+		MinFree:  100,
+		Interval: 5 * time.Millisecond,
+		Statfs: func(path string) (uint64, uint64, error) {
+			return 50, 1000, nil
+		},
+	})
+	defer g.Cancel()
+	expectAction(t, g, GracefulRestart)
+}
+
+func TestFDGuard_FakeProc(t *testing.T) {
+	root := t.TempDir()
+	fds := filepath.Join(root, "self", "fd")
+	if err := os.MkdirAll(fds, 0755); err != nil {
+		t.Fatal(err)
+	}
+	open := func(n int) {
+		for i := 0; i < n; i++ {
+			if err := os.WriteFile(filepath.Join(fds, strconv.Itoa(i)), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	open(3)
+	g := NewFDGuard(FDOptions{MaxOpen: 5, ProcRoot: root, Interval: 5 * time.Millisecond})
+	defer g.Cancel()
+	expectNoAction(t, g, 20*time.Millisecond)
+	open(6)
+	expectAction(t, g, GracefulRestart)
+}
+
+func TestFDGuard_Unreadable(t *testing.T) {
+	events := make(chan Event, 10)
+	g := NewFDGuard(FDOptions{ProcRoot: t.TempDir(), Interval: 5 * time.Millisecond, OnEvent: func(e Event) { events <- e }})
+	defer g.Cancel()
+	expectNoAction(t, g, 30*time.Millisecond)
+	if len(events) != 1 {
+		t.Error("expected the failure to be reported once, got: ", len(events))
+	}
+}
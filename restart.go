@@ -0,0 +1,81 @@
+package gracefully
+
+import (
+	"time"
+)
+
+// restartResetAfter is how long an iteration must run before the attempts counted for the RestartPolicy start over, like docker does
+const restartResetAfter = 10 * time.Second
+
+// RestartPolicy decides what the ServiceManager does when the routine returns on its own, without being asked to stop or restart.
+// Without a RestartPolicy, a routine returning nil is restarted right away and a routine returning an error stops the ServiceManager
+type RestartPolicy interface {
+	// Restart is given the error the routine returned, nil if none, and how many times in a row it returned on its own,
+	// starting at 1. The count starts over once an iteration runs for more than 10 seconds.
+	// Return restart=false to stop the ServiceManager, err will be returned by Wait. Otherwise the routine is called
+	// again after delay
+	Restart(err error, attempt int) (restart bool, delay time.Duration)
+}
+
+// RestartMode is when Restarts restarts the routine
+type RestartMode uint8
+
+const (
+	// RestartNever : the ServiceManager stops as soon as the routine returns on its own
+	RestartNever RestartMode = iota
+	// RestartOnFailure : the routine is restarted if it returned an error, the ServiceManager stops if it returned nil
+	RestartOnFailure
+	// RestartAlways : the routine is restarted whatever it returned
+	RestartAlways
+)
+
+// Backoff gives how long to wait before restarting the routine, given how many times in a row it returned, starting at 1
+type Backoff func(attempt int) time.Duration
+
+// ConstantBackoff waits the same amount of time before every restart
+func ConstantBackoff(d time.Duration) Backoff {
+	return func(int) time.Duration {
+		return d
+	}
+}
+
+// ExponentialBackoff waits initial before the first restart, then twice as long as the previous time, up to max
+func ExponentialBackoff(initial, max time.Duration) Backoff {
+	return func(attempt int) time.Duration {
+		d := initial
+		for i := 1; i < attempt && d < max; i++ {
+			d *= 2
+		}
+		if d > max {
+			d = max
+		}
+		return d
+	}
+}
+
+// Restarts is a RestartPolicy similar to the restart policies of docker
+type Restarts struct {
+	// Mode is when to restart
+	Mode RestartMode
+	// Backoff is how long to wait before restarting, nil to restart right away
+	Backoff Backoff
+	// MaxAttempts is how many times in a row the routine is restarted before giving up, 0 for no limit
+	MaxAttempts int
+}
+
+// Restart implements RestartPolicy
+func (r Restarts) Restart(err error, attempt int) (restart bool, delay time.Duration) {
+	switch r.Mode {
+	case RestartAlways:
+		restart = true
+	case RestartOnFailure:
+		restart = err != nil
+	}
+	if r.MaxAttempts > 0 && attempt > r.MaxAttempts {
+		restart = false
+	}
+	if restart && r.Backoff != nil {
+		delay = r.Backoff(attempt)
+	}
+	return
+}
@@ -0,0 +1,94 @@
+package gracefully
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRestartPolicy_OnFailure(t *testing.T) {
+	sm := New()
+	sm.AddSignaler(NewContextSignal())
+	sm.SetRestartPolicy(Restarts{Mode: RestartOnFailure, Backoff: ConstantBackoff(time.Millisecond)})
+	runs := 0
+	err := sm.Run(func(iCtx context.Context) error {
+		runs++
+		if runs < 3 {
+			return errors.New("failing")
+		}
+		// Succeeding means we're done
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if runs != 3 {
+		t.Error("expected 3 runs, got: ", runs)
+	}
+}
+
+func TestRestartPolicy_MaxAttempts(t *testing.T) {
+	sm := New()
+	sm.AddSignaler(NewContextSignal())
+	sm.SetRestartPolicy(Restarts{Mode: RestartAlways, MaxAttempts: 2})
+	runs := 0
+	err := sm.Run(func(iCtx context.Context) error {
+		runs++
+		return errors.New("failing")
+	})
+	if err == nil {
+		t.Error("expected the last error once out of attempts")
+	}
+	if runs != 3 {
+		t.Error("expected 3 runs, got: ", runs)
+	}
+}
+
+func TestRestartPolicy_StopDuringBackoff(t *testing.T) {
+	sm := New()
+	cs := NewContextSignal()
+	sm.AddSignaler(cs)
+	sm.SetRestartPolicy(Restarts{Mode: RestartAlways, Backoff: ConstantBackoff(time.Hour)})
+	runs := 0
+	err := sm.Run(func(iCtx context.Context) error {
+		runs++
+		cs.Stop()
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if runs != 1 {
+		t.Error("expected 1 run, got: ", runs)
+	}
+}
+
+func TestRestartPolicy_StopDuringBackoffKeepsError(t *testing.T) {
+	sm := New()
+	cs := NewContextSignal()
+	sm.AddSignaler(cs)
+	sm.SetRestartPolicy(Restarts{Mode: RestartOnFailure, Backoff: ConstantBackoff(time.Hour)})
+	failing := errors.New("failing")
+	err := sm.Run(func(iCtx context.Context) error {
+		go func() {
+			// Well into the backoff
+			time.Sleep(time.Second / 20)
+			cs.Stop()
+		}()
+		return failing
+	})
+	if !errors.Is(err, failing) {
+		t.Error("expected the error from the last run, got: ", err)
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	backoff := ExponentialBackoff(time.Second, 5*time.Second)
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, d := range expected {
+		if got := backoff(i + 1); got != d {
+			t.Error("attempt ", i+1, " expected ", d, " got: ", got)
+		}
+	}
+}
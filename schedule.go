@@ -0,0 +1,202 @@
+package gracefully
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// scheduleRecheck is the longest a Schedule sleeps before checking the wall clock again, which may have been changed
+const scheduleRecheck = time.Minute
+
+// ScheduleOptions configures a Schedule, set either Cron or Interval
+type ScheduleOptions struct {
+	// Action is what the ServiceManager should do when the schedule fires. Defaults to GracefulRestart
+	Action GracefulAction
+	// Cron is a standard 5-field cron expression, see ParseCron, such as "0 3 * * *" for every night at 3
+	Cron string
+	// Location is the time zone Cron is in. Defaults to time.Local
+	Location *time.Location
+	// Interval fires the schedule every Interval, plus up to Jitter
+	Interval time.Duration
+	// Jitter is a random amount of time, up to Jitter, added to every Interval, so that a fleet does not fire all at once
+	Jitter time.Duration
+}
+
+// Schedule is a SignalSelecter that tells the ServiceManager what to do at fixed times, such as restarting caches
+// nightly, or on a fixed interval.
+// Do not instantiate yourself, call: NewSchedule
+type Schedule struct {
+	BaseSignaler
+	action GracefulAction
+	// next gets the time the schedule fires next, after the given time
+	next func(time.Time) time.Time
+}
+
+// NewSchedule creates a Schedule that fires on opts.Cron or every opts.Interval
+func NewSchedule(opts ScheduleOptions) (*Schedule, error) {
+	var next func(time.Time) time.Time
+	switch {
+	case opts.Cron != "" && opts.Interval > 0:
+		return nil, errors.New("gracefully: schedule needs either Cron or Interval, not both")
+	case opts.Cron != "":
+		cron, err := ParseCron(opts.Cron, opts.Location)
+		if err != nil {
+			return nil, err
+		}
+		next = cron.Next
+	case opts.Interval > 0:
+		random := newRandom()
+		next = func(t time.Time) time.Time {
+			return t.Add(opts.Interval + random.between(0, opts.Jitter))
+		}
+	default:
+		return nil, errors.New("gracefully: schedule needs either Cron or Interval")
+	}
+	s := &Schedule{
+		BaseSignaler: NewBaseSignaler(),
+		action:       opts.Action,
+		next:         next,
+	}
+	go s.run()
+	return s, nil
+}
+
+// Cancel stops the schedule
+func (s *Schedule) Cancel() {
+	s.cancel()
+}
+
+// run fires the schedule until cancelled
+func (s *Schedule) run() {
+	at := s.next(time.Now())
+	for !at.IsZero() {
+		// Sleeping in steps, as timers don't follow changes to the wall clock, which cron goes by
+		wait := time.Until(at)
+		if wait > scheduleRecheck {
+			wait = scheduleRecheck
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-s.cancelled:
+			timer.Stop()
+			return
+		}
+		now := time.Now()
+		if now.Before(at) {
+			continue
+		}
+		if !s.sendAction(s.action) {
+			return
+		}
+		at = s.next(now)
+	}
+}
+
+// MaxLifetime is a SignalSelecter that restarts the routine once it ran for a random duration between a minimum and a
+// maximum, so that a fleet started at the same time does not restart all at once either. Each iteration gets a
+// lifetime of its own, counted from when it starts.
+// Do not instantiate yourself, call: NewMaxLifetime
+type MaxLifetime struct {
+	BaseSignaler
+	min, max time.Duration
+	random   *random
+	// mu protects timer and generation
+	mu sync.Mutex
+	// timer counts down to the restart, nil unless the routine is running
+	timer *time.Timer
+	// generation changes every time the timer is replaced, so that a timer firing as it's replaced is ignored
+	generation uint64
+}
+
+// NewMaxLifetime creates a MaxLifetime restarting the routine after a random duration in [min, max]
+func NewMaxLifetime(min, max time.Duration) *MaxLifetime {
+	if max < min {
+		max = min
+	}
+	return &MaxLifetime{
+		BaseSignaler: NewBaseSignaler(),
+		min:          min,
+		max:          max,
+		random:       newRandom(),
+	}
+}
+
+// Cancel stops counting
+func (m *MaxLifetime) Cancel() {
+	if m.cancel() {
+		m.disarm()
+	}
+}
+
+// iterationStarted starts counting down a new lifetime
+func (m *MaxLifetime) iterationStarted() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	select {
+	case <-m.cancelled:
+		return
+	default:
+	}
+	if m.timer != nil {
+		m.timer.Stop()
+	}
+	m.generation++
+	generation := m.generation
+	m.timer = time.AfterFunc(m.random.between(m.min, m.max), func() {
+		m.fire(generation)
+	})
+}
+
+// iterationEnded stops counting until the next iteration
+func (m *MaxLifetime) iterationEnded() {
+	m.disarm()
+}
+
+// disarm stops counting
+func (m *MaxLifetime) disarm() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.timer != nil {
+		m.timer.Stop()
+		m.timer = nil
+	}
+	m.generation++
+}
+
+// fire tells the ServiceManager to restart, unless the timer it's for was replaced in the meantime
+func (m *MaxLifetime) fire(generation uint64) {
+	m.mu.Lock()
+	current := generation == m.generation
+	if current {
+		m.timer = nil
+	}
+	m.mu.Unlock()
+	if !current {
+		return
+	}
+	m.sendAction(GracefulRestart)
+}
+
+// random is a source of random durations, safe to use from multiple goroutines
+type random struct {
+	mu     sync.Mutex
+	source *rand.Rand
+}
+
+// newRandom creates a random, seeded differently for every process
+func newRandom() *random {
+	return &random{source: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// between gets a random duration in [min, max]
+func (r *random) between(min, max time.Duration) time.Duration {
+	if max <= min {
+		return min
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return min + time.Duration(r.source.Int63n(int64(max-min)+1))
+}
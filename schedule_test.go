@@ -0,0 +1,80 @@
+package gracefully
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSchedule_Interval(t *testing.T) {
+	s, err := NewSchedule(ScheduleOptions{Action: GracefulStop, Interval: 10 * time.Millisecond, Jitter: 5 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Cancel()
+	expectAction(t, s, GracefulStop)
+	expectAction(t, s, GracefulStop)
+}
+
+func TestSchedule_Invalid(t *testing.T) {
+	for _, opts := range []ScheduleOptions{{}, {Cron: "nope"}, {Cron: "@daily", Interval: time.Hour}} {
+		if _, err := NewSchedule(opts); err == nil {
+			t.Error("expected an error for: ", opts)
+		}
+	}
+}
+
+func TestMaxLifetime_RestartsEachIteration(t *testing.T) {
+	sm := New()
+	cs := NewContextSignal()
+	sm.AddSignaler(cs)
+	sm.AddSignaler(NewMaxLifetime(10*time.Millisecond, 20*time.Millisecond))
+	iterations := 0
+	err := sm.Run(func(ctx context.Context) error {
+		iterations++
+		started := time.Now()
+		if iterations == 3 {
+			cs.Stop()
+		}
+		<-ctx.Done()
+		if iterations < 3 && time.Since(started) < 10*time.Millisecond {
+			t.Error("restarted before the minimum lifetime: ", time.Since(started))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if iterations != 3 {
+		t.Error("expected 3 iterations, got: ", iterations)
+	}
+}
+
+func TestMaxLifetime_CountsFromIterationStart(t *testing.T) {
+	const lifetime = 50 * time.Millisecond
+	m := NewMaxLifetime(lifetime, lifetime)
+	// Nothing counts before the routine runs
+	time.Sleep(2 * lifetime)
+	sm := New()
+	cs := NewContextSignal()
+	sm.AddSignaler(cs)
+	sm.AddSignaler(m)
+	var ran time.Duration
+	err := sm.Run(func(ctx context.Context) error {
+		started := time.Now()
+		if ran > 0 {
+			cs.Stop()
+		}
+		<-ctx.Done()
+		if ran == 0 {
+			ran = time.Since(started)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if ran < lifetime*4/5 {
+		t.Error("expected the first iteration to get its whole lifetime, ran: ", ran)
+	}
+}
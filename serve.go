@@ -0,0 +1,131 @@
+package gracefully
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// Servable is a server that accepts connections from a listener until it's shut down, such as http.Server.
+// Serve blocks until the server stops. Shutdown stops accepting new connections and waits for the ones in progress
+// to finish, giving up when ctx is done.
+type Servable interface {
+	Serve(l net.Listener) error
+	Shutdown(ctx context.Context) error
+}
+
+// Stopper is implemented by Servables that can be stopped immediately, abandoning any work in progress, such as grpc.Server.
+// Servables that are not Stoppers, but are io.Closers, are closed instead
+type Stopper interface {
+	Stop()
+}
+
+// ErrForcedStop is wrapped by the error returned from a ServeRoutine when the server did not shut down within the grace timeout
+var ErrForcedStop = errors.New("gracefully: server did not shut down in time and was stopped")
+
+// ErrServeAbandoned is wrapped by the error returned from a ServeRoutine when Serve did not return even after the server
+// was stopped, such as when it's neither a Stopper nor an io.Closer. Serve is left running in the background
+var ErrServeAbandoned = errors.New("gracefully: server did not stop serving, Serve was abandoned")
+
+// ServeOptions configures the routine created by ServeRoutine
+type ServeOptions struct {
+	// Network to listen on, defaults to "tcp"
+	Network string
+	// Address to listen on
+	Address string
+	// GraceTimeout is how long Shutdown gets before the server is stopped, and then how long Serve gets to return once
+	// stopped. Defaults to 30 seconds
+	GraceTimeout time.Duration
+}
+
+// ServeRoutine creates a routine for ServiceManager.Start or Run that serves on a socket opened with Listen until the
+// iteration's context is done, then shuts the server down. If Shutdown takes longer than GraceTimeout, the server is
+// stopped with Stop, or Close, and an error wrapping ErrForcedStop is returned. If Serve still does not return within
+// another GraceTimeout, it's abandoned and an error wrapping ErrServeAbandoned is returned.
+//
+// Most servers cannot serve again once shut down, so newServer is called to create a server for each iteration.
+// If Serve returns before the iteration is asked to stop, its error is returned as the routine's error
+func ServeRoutine(newServer func() Servable, opts ServeOptions) func(ctx context.Context) error {
+	if opts.Network == "" {
+		opts.Network = "tcp"
+	}
+	if opts.GraceTimeout <= 0 {
+		opts.GraceTimeout = defaultShutdownTimeout
+	}
+	return func(ctx context.Context) error {
+		l, err := Listen(ctx, opts.Network, opts.Address)
+		if err != nil {
+			return err
+		}
+		// Closing is harmless if Serve already did, the socket itself is owned by the ServiceManager
+		defer func() { _ = l.Close() }()
+
+		srv := newServer()
+		serveDone := make(chan error, 1)
+		go func() {
+			serveDone <- srv.Serve(l)
+		}()
+
+		serving := true
+		select {
+		case err = <-serveDone:
+			serving = false
+			if ctx.Err() == nil {
+				// Server stopped on its own, this is not something we asked for
+				return err
+			}
+			// Serve returned just as we were asked to stop, shut down anyway so the server can release its resources
+		case <-ctx.Done():
+		}
+
+		err = shutdownServable(srv, opts.GraceTimeout)
+		if !serving {
+			return err
+		}
+		if err == nil {
+			// Whatever Serve returns now is only telling us it was shut down
+			<-serveDone
+			return nil
+		}
+		// Stopping may not have been enough, or possible, no more accepting may be what makes Serve return
+		_ = l.Close()
+		select {
+		case <-serveDone:
+			return err
+		case <-time.After(opts.GraceTimeout):
+			return fmt.Errorf("%w: %v", ErrServeAbandoned, err)
+		}
+	}
+}
+
+// shutdownServable gracefully shuts srv down, stopping it if that takes longer than grace
+func shutdownServable(srv Servable, grace time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	shutdownDone := make(chan error, 1)
+	go func() {
+		shutdownDone <- srv.Shutdown(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-shutdownDone:
+	case <-ctx.Done():
+		// Not all servers respect the context, do not wait for them any longer
+		err = ctx.Err()
+	}
+	if err == nil {
+		return nil
+	}
+
+	switch hard := srv.(type) {
+	case Stopper:
+		hard.Stop()
+	case io.Closer:
+		_ = hard.Close()
+	}
+	return fmt.Errorf("%w: %v", ErrForcedStop, err)
+}
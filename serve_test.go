@@ -0,0 +1,86 @@
+package gracefully
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// stuckServer never finishes shutting down on its own, it has to be stopped
+type stuckServer struct {
+	serving chan bool
+	stopped chan bool
+	// released is closed once the test is over, to let Shutdown return
+	released chan bool
+}
+
+func (s *stuckServer) Serve(l net.Listener) error {
+	s.serving <- true
+	<-s.stopped
+	return errors.New("stopped")
+}
+
+func (s *stuckServer) Shutdown(ctx context.Context) error {
+	// Ignores ctx on purpose
+	<-s.released
+	return nil
+}
+
+func (s *stuckServer) Stop() {
+	close(s.stopped)
+}
+
+func TestServeRoutine_ForcedStop(t *testing.T) {
+	sm := New()
+	cs := NewContextSignal()
+	sm.AddSignaler(cs)
+	srv := &stuckServer{serving: make(chan bool), stopped: make(chan bool), released: make(chan bool)}
+	defer close(srv.released)
+	go func() {
+		<-srv.serving
+		cs.Stop()
+	}()
+	err := sm.Run(ServeRoutine(func() Servable {
+		return srv
+	}, ServeOptions{Address: "127.0.0.1:0", GraceTimeout: time.Second / 20}))
+	if !errors.Is(err, ErrForcedStop) {
+		t.Error("expected ErrForcedStop, got: ", err)
+	}
+}
+
+// unstoppableServer can't be stopped, and keeps serving after the listener is closed, until the test is over
+type unstoppableServer struct {
+	serving  chan bool
+	released chan bool
+}
+
+func (s *unstoppableServer) Serve(l net.Listener) error {
+	s.serving <- true
+	<-s.released
+	return nil
+}
+
+func (s *unstoppableServer) Shutdown(ctx context.Context) error {
+	<-s.released
+	return nil
+}
+
+func TestServeRoutine_AbandonsServe(t *testing.T) {
+	sm := New()
+	cs := NewContextSignal()
+	sm.AddSignaler(cs)
+	srv := &unstoppableServer{serving: make(chan bool), released: make(chan bool)}
+	defer close(srv.released)
+	go func() {
+		<-srv.serving
+		cs.Stop()
+	}()
+	err := sm.Run(ServeRoutine(func() Servable {
+		return srv
+	}, ServeOptions{Address: "127.0.0.1:0", GraceTimeout: time.Second / 20}))
+	if !errors.Is(err, ErrServeAbandoned) {
+		t.Error("expected ErrServeAbandoned, got: ", err)
+	}
+}
@@ -2,14 +2,17 @@ package gracefully
 
 import (
 	"context"
+	"errors"
 	"reflect"
 	"sync"
+	"time"
 )
 
 // ManagerStateEnum describe the state of the ServiceManager state machine
 // State flows thusly:
 // StateUnconfigured -> StateNew -> StateRunning <-> StateRestarting
-//                           V
+//                           V     ^
+//                           V     StatePaused
 //                         StateDying -> StateDead
 // Services can be restarted, the function provided to start is simply re-run in a new go-routine
 type ManagerStateEnum uint8
@@ -27,6 +30,8 @@ const (
 	StateDying
 	// StateDead means that this service is no longer running and all child routines should be shutdown
 	StateDead
+	// StatePaused means that the routine was stopped by GracefulPause, it will only be started again by GracefulResume or GracefulRestart
+	StatePaused
 )
 
 // ServiceManager contains the logic to control a contained service
@@ -46,6 +51,68 @@ type ServiceManager struct {
 	waitForIteratorDone chan error
 	// waitForRunning is how we know that the inner-goroutine has started
 	waitForRunning chan bool
+	// upgrader is used when a GracefulUpgrade is requested, nil if upgrades are not supported
+	upgrader Upgrader
+	// upgrading is true while the upgrader is starting our replacement, upgraded gets what to do once it's done
+	upgrading bool
+	upgraded  chan SignalControl
+	// listeners are the sockets shared by all iterations of the routine, they are closed once we reach StateDead
+	listeners *Listeners
+	// work counts the work in flight, see Track
+	work *workTracker
+	// drainTimeout is how long to wait for work in flight before cancelling the context on stop or restart
+	drainTimeout time.Duration
+	// restartPolicy decides what happens when the routine returns on its own, nil for the default behavior
+	restartPolicy RestartPolicy
+	// reaper waits on orphaned descendants, nil unless EnableInitMode was called
+	reaper *reaper
+	// resumed is closed to start the routine again once paused, nil unless paused
+	resumed chan struct{}
+	// stopHooks are called once the routine stopped, in reverse order, see AddStopHook
+	stopHooks []stopHook
+	// forced is closed by ForceStop
+	forced    chan struct{}
+	forceOnce sync.Once
+}
+
+// stopHook is a hook added with AddStopHook or AddCriticalStopHook
+type stopHook struct {
+	hook func()
+	// critical is true if the hook still runs when the stop is forced
+	critical bool
+}
+
+// ErrInitModeUnsupported is returned by EnableInitMode on platforms other than linux
+var ErrInitModeUnsupported = errors.New("gracefully: init mode is only supported on linux")
+
+// ErrAbandoned is returned by Wait and Run when ForceStop abandoned the routine
+var ErrAbandoned = errors.New("gracefully: stop forced, routine abandoned")
+
+// managerContextKey is how the ServiceManager running an iteration is found from the context handed to the routine
+type managerContextKey struct{}
+
+// managerFromContext gets the ServiceManager running the routine ctx was handed to, or nil if ctx did not come from a ServiceManager
+func managerFromContext(ctx context.Context) *ServiceManager {
+	s, _ := ctx.Value(managerContextKey{}).(*ServiceManager)
+	return s
+}
+
+// isRestarting is true if the ServiceManager running the routine ctx was handed to is restarting it.
+// Routines use this once ctx is done to tell a GracefulRestart apart from a GracefulStop
+func isRestarting(ctx context.Context) bool {
+	s := managerFromContext(ctx)
+	return s != nil && s.State() == StateRestarting
+}
+
+// iterationComesBack is true if the ServiceManager running the routine ctx was handed to will run it again, as it's
+// restarting or pausing it. Routines use this once ctx is done to tell a temporary shutdown apart from a GracefulStop
+func iterationComesBack(ctx context.Context) bool {
+	s := managerFromContext(ctx)
+	if s == nil {
+		return false
+	}
+	state := s.State()
+	return state == StateRestarting || state == StatePaused
 }
 
 // New creates a new ServiceManager, initialized and ready for use
@@ -55,6 +122,11 @@ func New() *ServiceManager {
 		state:               StateNew,
 		waitForIteratorDone: make(chan error, 1),
 		waitForRunning:      make(chan bool, 1),
+		upgraded:            make(chan SignalControl, 1),
+		listeners:           NewListeners(),
+		work:                newWorkTracker(),
+		drainTimeout:        defaultShutdownTimeout,
+		forced:              make(chan struct{}),
 	}
 }
 
@@ -73,9 +145,81 @@ func (s *ServiceManager) setState(st ManagerStateEnum) {
 }
 
 // AddSignaler appends a signaler interface to allow that signaler to interrupt this service while Waiting in either Wait or Run.
-// The behavior is undefined if a signaler is added after Run or Start are called before Wait completes.
+// It's safe to call at any time, but a signaler added after Run or Start are called, before Wait completes, may never be listened to.
 func (s *ServiceManager) AddSignaler(si SignalSelecter) {
+	s.mu.Lock()
 	s.signalers = append(s.signalers, si)
+	s.mu.Unlock()
+	if m, ok := si.(managedSignaler); ok {
+		m.managedBy(s)
+	}
+}
+
+// Listeners are the sockets that persist across iterations of the routine. See Listen
+func (s *ServiceManager) Listeners() *Listeners {
+	return s.listeners
+}
+
+// Work gets the stats of the work tracked with Track
+func (s *ServiceManager) Work() WorkStats {
+	return s.work.snapshot()
+}
+
+// SetDrainTimeout configures how long a stop or restart waits for the work tracked with Track to finish before the
+// routine's context is cancelled. Defaults to 30 seconds
+func (s *ServiceManager) SetDrainTimeout(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.drainTimeout = d
+}
+
+// SetRestartPolicy configures what happens when the routine returns on its own, see RestartPolicy
+func (s *ServiceManager) SetRestartPolicy(p RestartPolicy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.restartPolicy = p
+}
+
+// SetUpgrader configures how the ServiceManager will hand itself over to a new process when a GracefulUpgrade is requested.
+// Without an Upgrader, GracefulUpgrade is ignored and the service keeps running
+func (s *ServiceManager) SetUpgrader(u Upgrader) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.upgrader = u
+}
+
+// AddStopHook adds hook to what is called once the routine stopped, before Wait returns, such as closing a database.
+// Hooks are called in the reverse order they were added. When the stop is forced, see ForceStop, they're skipped, as the
+// routine may still be using what they release
+func (s *ServiceManager) AddStopHook(hook func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopHooks = append(s.stopHooks, stopHook{hook: hook})
+}
+
+// AddCriticalStopHook adds hook like AddStopHook, but it's also called when the stop is forced, such as flushing logs.
+// It must not wait on the routine, which may never return
+func (s *ServiceManager) AddCriticalStopHook(hook func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopHooks = append(s.stopHooks, stopHook{hook: hook, critical: true})
+}
+
+// EnableInitMode makes the ServiceManager behave like an init process, for when it's PID 1 in a container.
+// This process becomes the child subreaper of its descendants, and once started, zombies re-parented to it are waited on.
+// Processes started with os/exec are left alone, their exit status is for exec.Cmd.Wait. This relies on the pidfd the os
+// package holds for them, with go 1.23 and linux 5.4 or newer.
+// Once the routine is done stopping, every remaining descendant is sent SIGTERM, and given up to the drain timeout to exit.
+// Must be called before Start, linux only
+func (s *ServiceManager) EnableInitMode() error {
+	r, err := newReaper()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reaper = r
+	return nil
 }
 
 // Start runs the routine in a goroutine and returns immediately if there was an error that prevented the process from starting
@@ -91,19 +235,34 @@ func (s *ServiceManager) AddSignaler(si SignalSelecter) {
 //
 // Once routine exits, you do not have control over ServiceManager. ServiceManager will restart it if it is told to do so, or it will not if told to stop
 func (s *ServiceManager) Start(routine func(ctx context.Context) error) {
-	var subCtx context.Context
 	s.mu.Lock()
-	subCtx, s.cancelFunc = context.WithCancel(context.Background())
+	subCtx := s.newIterationContext()
+	if s.reaper != nil {
+		s.reaper.start()
+	}
 	s.mu.Unlock()
 	go func() {
 		s.setState(StateRunning)
 		s.waitForRunning <- true
 
 		var err error
+		// attempt is how many times in a row the routine returned on its own, for the RestartPolicy
+		attempt := 0
 		running := true
 		for running {
 			// Run the function provided by the user
+			started := time.Now()
+			s.notifyIteration(true)
 			err = routine(subCtx)
+			s.notifyIteration(false)
+			ran := time.Since(started)
+			select {
+			case <-s.forced:
+				// Abandoned by ForceStop, nothing is left to decide, and Wait is not waiting for us anymore
+				s.waitForIteratorDone <- err
+				return
+			default:
+			}
 			// Clean up the context to release resources
 			s.mu.Lock()
 			if s.cancelFunc != nil {
@@ -115,10 +274,28 @@ func (s *ServiceManager) Start(routine func(ctx context.Context) error) {
 				s.state = StateDying
 			}
 			currentState := s.state
+			policy := s.restartPolicy
 			s.mu.Unlock()
 			// function returned, it's 1 of 3 reasons:
 			// #1: the method had an error and returned abnormally, in which case, by-pass restart, and end
-			if err != nil {
+			// unless it returned on its own and the RestartPolicy says otherwise
+			var delay time.Duration
+			// failed is the error the routine returned, even if the RestartPolicy forgave it, it's what we return if
+			// told to stop before it runs again
+			failed := err
+			if currentState == StateRunning && policy != nil {
+				if ran >= restartResetAfter {
+					attempt = 0
+				}
+				attempt++
+				var restart bool
+				if restart, delay = policy.Restart(err, attempt); restart {
+					err = nil
+				} else {
+					currentState = StateDying
+					s.setState(StateDying)
+				}
+			} else if err != nil {
 				currentState = StateDying
 				s.setState(StateDying)
 			}
@@ -126,6 +303,17 @@ func (s *ServiceManager) Start(routine func(ctx context.Context) error) {
 			// #2: If no error, then the process could have been cancelled by the context
 			// If cancelled, we'll know about it because the context will be closed and the state will no longer be running
 			// If we've been cancelled by some other Signaler, do not hang on the context cancel
+			if currentState == StatePaused {
+				// Nothing to run until we're told to resume, or to stop
+				s.mu.Lock()
+				resumed := s.resumed
+				s.mu.Unlock()
+				if resumed != nil {
+					<-resumed
+				}
+				currentState = StateRestarting
+			}
+
 			switch currentState {
 			case StateRunning, StateRestarting:
 				// #3: The function may have just returned for some reason
@@ -133,10 +321,26 @@ func (s *ServiceManager) Start(routine func(ctx context.Context) error) {
 				// we're restarting, so just create a new context and re-loop
 				// Create a new context
 				s.mu.Lock()
-				subCtx, s.cancelFunc = context.WithCancel(context.Background())
+				subCtx = s.newIterationContext()
+				// We're re-entering, unless we were told to stop in the meantime
+				switch s.state {
+				case StateRestarting:
+					s.state = StateRunning
+				case StateDying:
+					// Stopped after the routine returned, there was no context left to cancel
+					s.cancelFunc()
+					s.cancelFunc = nil
+					running = false
+					err = failed
+				}
 				s.mu.Unlock()
+				// The RestartPolicy may want us to wait, but being told to stop in the meantime ends the wait
+				if running && delay > 0 && !s.sleep(subCtx, delay) {
+					running = false
+					err = failed
+				}
 			default:
-				// Includes any state other than StateRunning or StateRestarting, including StateNew, StateDying, StateDead
+				// Includes any state other than StateRunning, StateRestarting or StatePaused, including StateNew, StateDying, StateDead
 				// StateNew should be impossible, as we wait until the system is running to get to this point
 				// StateDead is also impossible as we set that in Wait, nothing else ever sets that state
 				// we're not restarting, but stopping
@@ -149,6 +353,99 @@ func (s *ServiceManager) Start(routine func(ctx context.Context) error) {
 	}()
 }
 
+// notifyIteration tells the signalers that want to know that an iteration of the routine started, or ended
+func (s *ServiceManager) notifyIteration(started bool) {
+	s.mu.Lock()
+	signalers := append([]SignalSelecter{}, s.signalers...)
+	s.mu.Unlock()
+	for _, si := range signalers {
+		w, ok := si.(iterationWatcher)
+		switch {
+		case !ok:
+		case started:
+			w.iterationStarted()
+		default:
+			w.iterationEnded()
+		}
+	}
+}
+
+// managedSignaler is implemented by signalers that act on the ServiceManager directly, not only through SignalControl,
+// such as to force a stop while Wait is not listening to them
+type managedSignaler interface {
+	// managedBy is called when the signaler is added to the ServiceManager
+	managedBy(s *ServiceManager)
+}
+
+// deathWatcher is implemented by signalers that hold on to something until the ServiceManager is done with it, such as a
+// file being written to
+type deathWatcher interface {
+	// managerDead is called once the ServiceManager reached StateDead
+	managerDead()
+}
+
+// notifyDead tells the signalers that want to know that we reached StateDead
+func (s *ServiceManager) notifyDead() {
+	s.mu.Lock()
+	signalers := append([]SignalSelecter{}, s.signalers...)
+	s.mu.Unlock()
+	for _, si := range signalers {
+		if w, ok := si.(deathWatcher); ok {
+			w.managerDead()
+		}
+	}
+}
+
+// drainWatcher is implemented by signalers that need to know when the iteration stops taking new work, such as to stop
+// counting time it spends winding down
+type drainWatcher interface {
+	// drainStarted is called before the work in flight is waited for, the iteration's context is cancelled after that
+	drainStarted()
+}
+
+// notifyDrain tells the signalers that want to know that the iteration is winding down
+func (s *ServiceManager) notifyDrain() {
+	s.mu.Lock()
+	signalers := append([]SignalSelecter{}, s.signalers...)
+	s.mu.Unlock()
+	for _, si := range signalers {
+		if w, ok := si.(drainWatcher); ok {
+			w.drainStarted()
+		}
+	}
+}
+
+// sleep waits for d before the next iteration starts with ctx
+// @return false if the ServiceManager was told to stop in the meantime
+func (s *ServiceManager) sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		// A restart while waiting just means starting right away
+		return s.State() != StateDying
+	}
+}
+
+// newIterationContext creates the context handed to a single iteration of the routine. Caller must hold mu
+func (s *ServiceManager) newIterationContext() (ctx context.Context) {
+	s.work.accept()
+	ctx, s.cancelFunc = context.WithCancel(context.WithValue(context.Background(), managerContextKey{}, s))
+	return
+}
+
+// ForceStop abandons the routine, for when a stop takes too long, such as Ctrl-C pressed again. Wait returns
+// ErrAbandoned right away, without waiting for the work in flight or for the routine to return. Only what has to happen
+// still does: the signalers are cancelled, the listeners closed, the critical stop hooks called and StateDead reached,
+// but the other stop hooks are skipped and the descendants of an init process are not waited on
+func (s *ServiceManager) ForceStop() {
+	s.forceOnce.Do(func() {
+		close(s.forced)
+	})
+}
+
 // Wait will block the caller and wait for the configured Signalers to push an item onto their channels.
 //
 // Wait will block until the main service GoRoutine has ended. This is signalled by a push to the waitForIteratorDone channel
@@ -164,11 +461,27 @@ func (s *ServiceManager) Wait() (err error) {
 	s.waitForRunning = nil
 
 	running := true
+	// abandoned is true if the routine was left running by ForceStop
+	abandoned := false
 	for running {
 		// chosen is the index of the selected case
 		// recv is the value obtained, which will always be a SignalControl function
 		// ok = false if the channel is closed
 		chosen, recv, ok := reflect.Select(cases)
+		if chosen == len(cases)-1 {
+			// ForceStop was called, whatever the routine is doing
+			running, abandoned = false, true
+			err = ErrAbandoned
+			s.mu.Lock()
+			s.state = StateDying
+			if s.cancelFunc != nil {
+				s.cancelFunc()
+				s.cancelFunc = nil
+			}
+			s.resumeLocked()
+			s.mu.Unlock()
+			break
+		}
 		if !ok {
 			// not OK: channel was closed, remove from the list as we'll never receive any messages on it
 			// It makes no sense to listen to it any more
@@ -181,7 +494,11 @@ func (s *ServiceManager) Wait() (err error) {
 				// we're out of channels, stop the for loop
 				// We'll need to wait for the server to end-itself. Closing channels does not stop the service,
 				// but only the means of stopping that service
-				err = <-s.waitForIteratorDone
+				select {
+				case err = <-s.waitForIteratorDone:
+				case <-s.forced:
+					err, abandoned = ErrAbandoned, true
+				}
 				break
 			}
 			// we need to re-build the missing cases as now one is missing
@@ -196,32 +513,77 @@ func (s *ServiceManager) Wait() (err error) {
 			switch chanType(s) {
 			case GracefulRestart:
 				// We need to gracefully restart
+				// Let the work in flight finish first, nobody should mistake us for running in the meantime
+				s.setState(StateRestarting)
+				s.drain()
 				// Trigger cancelling the context
 				// We copy the value and set it to nil here to avoid having the inner go-routine call cancel a second time
 				s.mu.Lock()
-				s.state = StateRestarting
 				if s.cancelFunc != nil {
 					s.cancelFunc()
 					s.cancelFunc = nil
 				}
+				// Restarting a paused routine is just starting it again
+				s.resumeLocked()
 				s.mu.Unlock()
 
+			case GracefulPause:
+				if s.State() != StateRunning {
+					break
+				}
+				// Same as a stop, but we'll be back
+				s.mu.Lock()
+				s.state = StatePaused
+				s.resumed = make(chan struct{})
+				s.mu.Unlock()
+				s.drain()
+				s.mu.Lock()
+				if s.cancelFunc != nil {
+					s.cancelFunc()
+					s.cancelFunc = nil
+				}
+				s.mu.Unlock()
+
+			case GracefulResume:
+				s.mu.Lock()
+				if s.state == StatePaused {
+					s.state = StateRestarting
+					s.resumeLocked()
+				}
+				s.mu.Unlock()
+
+			case GracefulUpgrade:
+				// Start our replacement in the background, we keep serving and listening to the signalers until it's
+				// ready to take over, see upgrade
+				s.upgrade()
+
 			case GracefulStop:
 				// We need to stop the service
 				running = false
 
+				// Let the work in flight finish before cancelling the context
+				s.setState(StateDying)
+				s.drain()
 				s.mu.Lock()
-				s.state = StateDying
 				if s.cancelFunc != nil {
 					s.cancelFunc()
 					s.cancelFunc = nil
 				}
+				// A paused routine is waiting to find out that it's not coming back
+				s.resumeLocked()
 				s.mu.Unlock()
 
-				// We're stopping, we need to wait for the goroutine to signal that it completed
-				err = <-s.waitForIteratorDone
+				// We're stopping, we need to wait for the goroutine to signal that it completed, unless forced not to
+				select {
+				case err = <-s.waitForIteratorDone:
+				case <-s.forced:
+					err, abandoned = ErrAbandoned, true
+				}
+
+			case GracefulNone:
+				// Whatever needed doing was done by the signaler, keep going
 			}
-		case error:
+		case error, nil:
 			// This means our routine completed and is no longer running
 			running = false
 			s.setState(StateDying)
@@ -229,7 +591,7 @@ func (s *ServiceManager) Wait() (err error) {
 			// The main service routine ended so we CANNOT wait for the goroutine to signal that it completed as it's already done
 			// this also means that the context has already cleaned itself up, so no need to call s.cancelFunc
 			// chanType could be nil, meaning no error
-			err = chanType
+			err, _ = chanType.(error)
 
 		}
 	}
@@ -237,13 +599,90 @@ func (s *ServiceManager) Wait() (err error) {
 	// Recover goroutine leak, if any
 	s.cancelSignalers()
 
+	// No more iterations will run, the sockets can finally be released
+	_ = s.listeners.Close()
+
+	// As an init process, nothing we started should outlive us
+	s.mu.Lock()
+	r, timeout := s.reaper, s.drainTimeout
+	s.mu.Unlock()
+	if r != nil {
+		// Not when forced, the descendants may well be why the stop took too long
+		if !abandoned {
+			r.terminate(timeout)
+		}
+		r.stop()
+	}
+	s.runStopHooks(abandoned)
+
 	s.setState(StateDead)
+	s.notifyDead()
+
+	if abandoned {
+		// The routine may still return and send its error, which must not panic
+		return
+	}
 
 	close(s.waitForIteratorDone)
 
 	return
 }
 
+// runStopHooks calls the stop hooks, last added first, only the critical ones if the routine was abandoned
+func (s *ServiceManager) runStopHooks(abandoned bool) {
+	s.mu.Lock()
+	hooks := s.stopHooks
+	s.mu.Unlock()
+	for i := len(hooks) - 1; i >= 0; i-- {
+		if hooks[i].critical || !abandoned {
+			hooks[i].hook()
+		}
+	}
+}
+
+// resumeLocked lets a paused routine go on, to whatever the state now says. Caller must hold mu
+func (s *ServiceManager) resumeLocked() {
+	if s.resumed != nil {
+		close(s.resumed)
+		s.resumed = nil
+	}
+}
+
+// drain stops new work from being tracked and waits for the work in flight to finish, up to the drain timeout
+func (s *ServiceManager) drain() {
+	s.notifyDrain()
+	s.mu.Lock()
+	timeout := s.drainTimeout
+	s.mu.Unlock()
+	s.work.drain(timeout, s.forced)
+}
+
+// upgrade asks the configured Upgrader, if any, to start the process that will replace this one, unless it's already
+// doing so. Once it's done, Wait gets a GracefulStop to get out of the replacement's way, or, if it failed, a
+// GracefulNone, as there is nothing else to do but keep serving
+func (s *ServiceManager) upgrade() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.upgrader
+	if u == nil || s.upgrading {
+		return
+	}
+	s.upgrading = true
+	go func() {
+		err := u.Upgrade()
+		// Never blocks, only one upgrade is in progress at a time
+		s.upgraded <- func(s *ServiceManager) GracefulAction {
+			s.mu.Lock()
+			s.upgrading = false
+			s.mu.Unlock()
+			if err != nil {
+				return GracefulNone
+			}
+			return GracefulStop
+		}
+	}()
+}
+
 // cancelSignalers instructs the added Signalers to bail out of their goroutines, if any
 // some Signalers may be running their own goroutines, they need to be told to exit
 func (s *ServiceManager) cancelSignalers() {
@@ -263,17 +702,25 @@ func (s *ServiceManager) removeSignaler(index int) bool {
 	return false
 }
 
-// buildSelectCases given the current Signalers creates the reflect.SelectCase's for all Signalers, plus the service routine's completion channel
+// buildSelectCases given the current Signalers creates the reflect.SelectCase's for all Signalers, plus the outcome of
+// an upgrade, plus the service routine's completion channel, plus ForceStop, last
 func (s *ServiceManager) buildSelectCases() []reflect.SelectCase {
-	cases := make([]reflect.SelectCase, len(s.signalers)+1)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cases := make([]reflect.SelectCase, len(s.signalers)+3)
 	for i, value := range s.signalers {
 		cases[i] = reflect.SelectCase{
 			Dir:  reflect.SelectRecv,
 			Chan: reflect.ValueOf(value.Select()),
 		}
 	}
+	// upgraded is never closed, so it's never mistaken for a Signaler to remove
+	cases[len(cases)-3].Chan = reflect.ValueOf(s.upgraded)
+	cases[len(cases)-3].Dir = reflect.SelectRecv
 	// add the waitForIterationDone
-	cases[len(cases)-1].Chan = reflect.ValueOf(s.waitForIteratorDone)
+	cases[len(cases)-2].Chan = reflect.ValueOf(s.waitForIteratorDone)
+	cases[len(cases)-2].Dir = reflect.SelectRecv
+	cases[len(cases)-1].Chan = reflect.ValueOf(s.forced)
 	cases[len(cases)-1].Dir = reflect.SelectRecv
 	return cases
 }
@@ -3,6 +3,7 @@ package gracefully
 import (
 	"context"
 	"errors"
+	"fmt"
 	"os"
 	"testing"
 	"time"
@@ -61,6 +62,29 @@ func TestNewServiceManager_ContextSignalRestart(t *testing.T) {
 	}
 }
 
+func TestNewServiceManager_RunningAgainAfterRestart(t *testing.T) {
+	sm := New()
+	cs := NewContextSignal()
+	sm.AddSignaler(cs)
+	states := make([]ManagerStateEnum, 0, 2)
+	err := sm.Run(func(iCtx context.Context) error {
+		states = append(states, sm.State())
+		if len(states) == 1 {
+			cs.Restart()
+		} else {
+			cs.Stop()
+		}
+		<-iCtx.Done()
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if len(states) != 2 || states[0] != StateRunning || states[1] != StateRunning {
+		t.Error("expected each iteration to run in StateRunning, got: ", states)
+	}
+}
+
 // Tests that SIgnalers whose channels are closed are removed from the system properly
 func TestNewServiceManager_CloseChannel(t *testing.T) {
 	//t.SkipNow()
@@ -182,3 +206,263 @@ func TestNewServiceManager_RunReturnsError(t *testing.T) {
 		t.Error("expected an error")
 	}
 }
+
+type testUpgrader struct {
+	err   error
+	calls int
+	// release, if set, is waited on before returning
+	release chan bool
+	// returned, if set, is sent to once Upgrade returns
+	returned chan bool
+}
+
+func (u *testUpgrader) Upgrade() error {
+	u.calls++
+	if u.release != nil {
+		<-u.release
+	}
+	if u.returned != nil {
+		u.returned <- true
+	}
+	return u.err
+}
+
+func TestNewServiceManager_UpgradeStops(t *testing.T) {
+	up := &testUpgrader{}
+	sm := New()
+	sm.SetUpgrader(up)
+	cs := NewContextSignal()
+	sm.AddSignaler(cs)
+	go func() {
+		cs.OnSignal <- func(manager *ServiceManager) GracefulAction {
+			return GracefulUpgrade
+		}
+	}()
+	err := sm.Run(func(iCtx context.Context) error {
+		<-iCtx.Done()
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if up.calls != 1 {
+		t.Error("expected Upgrade to be called once, got: ", up.calls)
+	}
+}
+
+func TestNewServiceManager_UpgradeFailureKeepsRunning(t *testing.T) {
+	up := &testUpgrader{err: errors.New("child failed"), returned: make(chan bool, 1)}
+	sm := New()
+	sm.SetUpgrader(up)
+	cs := NewContextSignal()
+	sm.AddSignaler(cs)
+	go func() {
+		cs.OnSignal <- func(manager *ServiceManager) GracefulAction {
+			return GracefulUpgrade
+		}
+		<-up.returned
+		cs.Stop()
+	}()
+	iterations := 0
+	err := sm.Run(func(iCtx context.Context) error {
+		iterations++
+		<-iCtx.Done()
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if up.calls != 1 {
+		t.Error("expected Upgrade to be called once, got: ", up.calls)
+	}
+	if iterations != 1 {
+		t.Error("expected the routine to keep running through the failed upgrade, got iterations: ", iterations)
+	}
+}
+
+func TestNewServiceManager_SignalsHandledWhileUpgrading(t *testing.T) {
+	up := &testUpgrader{release: make(chan bool)}
+	sm := New()
+	sm.SetUpgrader(up)
+	cs := NewContextSignal()
+	sm.AddSignaler(cs)
+	go func() {
+		cs.OnSignal <- func(manager *ServiceManager) GracefulAction {
+			return GracefulUpgrade
+		}
+		// Asking for another upgrade while the first one is not done yet does nothing
+		cs.OnSignal <- func(manager *ServiceManager) GracefulAction {
+			return GracefulUpgrade
+		}
+		cs.Restart()
+	}()
+	iterations := 0
+	err := sm.Run(func(iCtx context.Context) error {
+		iterations++
+		if iterations == 2 {
+			// Restarted while the replacement is still starting, now it's ready
+			close(up.release)
+		}
+		<-iCtx.Done()
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if up.calls != 1 {
+		t.Error("expected Upgrade to be called once, got: ", up.calls)
+	}
+	if iterations != 2 {
+		t.Error("expected the restart to be handled during the upgrade, got iterations: ", iterations)
+	}
+}
+
+func TestNewServiceManager_ForceStopAbandonsRoutine(t *testing.T) {
+	sm := New()
+	cs := NewContextSignal()
+	sm.AddSignaler(cs)
+	// A routine that does not stop when told to, returning an error once it finally does
+	release := make(chan struct{})
+	returned := make(chan struct{})
+	sm.Start(func(iCtx context.Context) error {
+		defer close(returned)
+		<-release
+		return errors.New("late")
+	})
+	go sm.ForceStop()
+	err := sm.Wait()
+	if !errors.Is(err, ErrAbandoned) {
+		t.Error("expected ErrAbandoned, got: ", err)
+	}
+	if sm.State() != StateDead {
+		t.Error("expected StateDead, got: ", sm.State())
+	}
+	// The abandoned routine returning later changes nothing
+	close(release)
+	<-returned
+	time.Sleep(10 * time.Millisecond)
+	if sm.State() != StateDead {
+		t.Error("expected StateDead to stick, got: ", sm.State())
+	}
+}
+
+func TestServiceManager_StopHooks(t *testing.T) {
+	for _, forced := range []bool{false, true} {
+		sm := New()
+		cs := NewContextSignal()
+		sm.AddSignaler(cs)
+		called := make([]string, 0, 3)
+		sm.AddCriticalStopHook(func() { called = append(called, "first critical") })
+		sm.AddStopHook(func() { called = append(called, "regular") })
+		sm.AddCriticalStopHook(func() { called = append(called, "last critical") })
+		release := make(chan struct{})
+		sm.Start(func(iCtx context.Context) error {
+			<-iCtx.Done()
+			<-release
+			return nil
+		})
+		if forced {
+			go sm.ForceStop()
+		} else {
+			close(release)
+			cs.Stop()
+		}
+		_ = sm.Wait()
+		expected := "[last critical regular first critical]"
+		if forced {
+			expected = "[last critical first critical]"
+			close(release)
+		}
+		if fmt.Sprint(called) != expected {
+			t.Error("expected ", expected, " when forced is ", forced, ", got: ", called)
+		}
+	}
+}
+
+// expectAction waits for s to tell the ServiceManager to take action
+func expectAction(t *testing.T, s SignalSelecter, expected GracefulAction) {
+	t.Helper()
+	select {
+	case control := <-s.Select():
+		if action := control(nil); action != expected {
+			t.Error("expected action ", expected, " got: ", action)
+		}
+	case <-time.After(time.Second):
+		t.Error("expected action ", expected, " got nothing")
+	}
+}
+
+// expectNoAction fails if s tells the ServiceManager to do anything within d
+func expectNoAction(t *testing.T, s SignalSelecter, d time.Duration) {
+	t.Helper()
+	select {
+	case control := <-s.Select():
+		t.Error("expected no action, got: ", control(nil))
+	case <-time.After(d):
+	}
+}
+
+func TestServiceManager_PauseResume(t *testing.T) {
+	sm := New()
+	cs := NewContextSignal()
+	sm.AddSignaler(cs)
+	iterations := 0
+	paused := make(chan bool, 1)
+	err := sm.Run(func(ctx context.Context) error {
+		iterations++
+		switch iterations {
+		case 1:
+			cs.Pause()
+			<-ctx.Done()
+			go func() {
+				// Nothing runs while paused
+				time.Sleep(20 * time.Millisecond)
+				paused <- sm.State() == StatePaused
+				cs.Resume()
+			}()
+		case 2:
+			cs.Stop()
+			<-ctx.Done()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if !<-paused {
+		t.Error("expected StatePaused while paused")
+	}
+	if iterations != 2 {
+		t.Error("expected 2 iterations, got: ", iterations)
+	}
+}
+
+func TestServiceManager_StopWhilePaused(t *testing.T) {
+	sm := New()
+	cs := NewContextSignal()
+	sm.AddSignaler(cs)
+	iterations := 0
+	err := sm.Run(func(ctx context.Context) error {
+		iterations++
+		cs.Pause()
+		<-ctx.Done()
+		go cs.Stop()
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if iterations != 1 {
+		t.Error("expected 1 iteration, got: ", iterations)
+	}
+}
+
+func TestBaseSignaler_CancelWithoutConstructor(t *testing.T) {
+	var s BaseSignaler
+	if !s.cancel() {
+		t.Error("expected the first cancel to count")
+	}
+	if s.cancel() {
+		t.Error("expected cancel to only happen once")
+	}
+}
@@ -1,36 +1,103 @@
 package gracefully
 
 import (
+	"fmt"
+	"io"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
+	"time"
 )
 
 // Signals is how we configured the operating system signals for our Gracefully service manager
-// Do not instantiate yourself, call: NewSignals or DefaultSignals
+// Do not instantiate yourself, call: NewSignals, NewSignalHandlers or DefaultSignals
 type Signals struct {
 	// signalChan is where to put incoming signals from the OS
 	signalChan chan os.Signal
-	// actions is what the ServiceManager should do when a signal is received
-	actions map[os.Signal]GracefulAction
+	// handlers is what to do when a signal is received
+	handlers map[os.Signal]SignalHandler
+	// onEvent, if set, is told about callbacks that failed, and about stops being escalated
+	onEvent EventHandler
+	// mu protects escalation, stopping, stops and manager
+	mu         sync.Mutex
+	escalation Escalation
+	// stopping is true once a stop signal was handed to the ServiceManager
+	stopping bool
+	// stops are when the stop signals were received, within the escalation window
+	stops []time.Time
+	// manager is the ServiceManager we were added to, to force the stop on
+	manager *ServiceManager
 	BaseSignaler
 }
 
+// Escalation is how Signals force a stop when stop signals keep coming while stopping, such as Ctrl-C pressed again,
+// like docker and kubectl do. The first stop signal is handed to the ServiceManager as usual, and tells where to send more
+// to force the stop. Once there are Count of them, the ServiceManager is told to ForceStop
+type Escalation struct {
+	// Count is how many stop signals, the first one included, force the stop. Below 2 disables escalation, every stop
+	// signal is then handed to the ServiceManager
+	Count int
+	// Window is how close together the stop signals must be to count, 0 counts them however far apart
+	Window time.Duration
+	// Output is where to tell the user how to force the stop, nil for nowhere
+	Output io.Writer
+}
+
+// defaultEscalation forces the stop on the second stop signal, telling the user so on stderr, as most command line tools do
+var defaultEscalation = Escalation{Count: 2, Output: os.Stderr}
+
+// SignalHandler is what to do when a signal is received: have the ServiceManager take Action, or call Callback
+type SignalHandler struct {
+	// Action is what the ServiceManager should do, unless there is a Callback
+	Action GracefulAction
+	// Callback, if set, is called with the ServiceManager instead, such as to toggle debug logging, and the ServiceManager
+	// carries on as if it got GracefulNone. It's called from Wait, so it should return quickly. A panic is recovered, and
+	// reported like an error
+	Callback func(manager *ServiceManager) error
+}
+
 // NewSignals creates a new SignalSelecter that listens for operating system signals that you specify, such as SIGINT, SIGHUP, SIGTERM, etc.
 func NewSignals(signalsAndActions map[os.Signal]GracefulAction) *Signals {
+	return newSignals(actionHandlers(signalsAndActions), nil, nil)
+}
+
+// NewSignalHandlers creates a new SignalSelecter like NewSignals, where signals can also be handled by callbacks, such as
+// SIGUSR1 to reopen log files. Callbacks that fail, or panic, are reported to onEvent, if set
+func NewSignalHandlers(signalsAndHandlers map[os.Signal]SignalHandler, onEvent EventHandler) *Signals {
+	return newSignals(signalsAndHandlers, onEvent, nil)
+}
+
+// actionHandlers creates the handlers for signals that are only mapped to actions
+func actionHandlers(signalsAndActions map[os.Signal]GracefulAction) map[os.Signal]SignalHandler {
+	handlers := make(map[os.Signal]SignalHandler, len(signalsAndActions))
+	for sig, action := range signalsAndActions {
+		handlers[sig] = SignalHandler{Action: action}
+	}
+	return handlers
+}
+
+// newSignals creates the Signals SignalSelecter. The forwarded signals are handed to forward, and are only handled if they are also in signalsAndHandlers
+func newSignals(signalsAndHandlers map[os.Signal]SignalHandler, onEvent EventHandler, forward func(os.Signal), forwarded ...os.Signal) *Signals {
 	s := &Signals{
 		// opting for size 2 to ensure that the os.Notify does not block
 		signalChan: make(chan os.Signal, 2),
-		// actions allows users to specify how they want to handle signals
-		actions:      signalsAndActions,
+		// handlers allows users to specify how they want to handle signals
+		handlers:     signalsAndHandlers,
+		onEvent:      onEvent,
 		BaseSignaler: NewBaseSignaler(),
 	}
 
 	// Extract signals
-	sigs := make([]os.Signal, 0, len(signalsAndActions))
-	for key := range signalsAndActions {
+	sigs := make([]os.Signal, 0, len(signalsAndHandlers)+len(forwarded))
+	for key := range signalsAndHandlers {
 		sigs = append(sigs, key)
 	}
+	sigs = append(sigs, forwarded...)
+	isForwarded := make(map[os.Signal]bool, len(forwarded))
+	for _, sig := range forwarded {
+		isForwarded[sig] = true
+	}
 
 	// Listen for those signals
 	signal.Notify(s.signalChan, sigs...)
@@ -39,13 +106,34 @@ func NewSignals(signalsAndActions map[os.Signal]GracefulAction) *Signals {
 	// This goroutine will wait for signals to come in from the OS, look up what to do from the map then signal to the
 	// ServiceManager what it needs to do.
 	go func(routineSig *Signals) {
+		// pending is what the ServiceManager was not told yet. Signals keep being received meanwhile, so that stop signals
+		// are counted while Wait is busy, such as draining for a restart, and can force the stop
+		pending := make([]SignalControl, 0, 2)
 		for {
+			// Nothing to tell, the nil channel is never ready
+			var onSignal chan SignalControl
+			var next SignalControl
+			if len(pending) > 0 {
+				onSignal, next = routineSig.OnSignal, pending[0]
+			}
 			select {
+			case onSignal <- next:
+				pending = pending[1:]
 			case gotSignal := <-routineSig.signalChan:
 				// Operating system sent us an error
-				routineSig.OnSignal <- func(manager *ServiceManager) GracefulAction {
-					return routineSig.actions[gotSignal]
+				// Forwarded signals are only handled if asked for both
+				if isForwarded[gotSignal] {
+					forward(gotSignal)
+					if _, ok := routineSig.handlers[gotSignal]; !ok {
+						continue
+					}
 				}
+				handler := routineSig.handlers[gotSignal]
+				if handler.Callback == nil && handler.Action == GracefulStop && !routineSig.stopReceived(gotSignal) {
+					// Wait is busy stopping, it's not listening anymore
+					continue
+				}
+				pending = append(pending, routineSig.control(gotSignal, handler))
 			case <-routineSig.OnCancel:
 				// We got a OnCancel, end the loop to prevent go routine from leaking
 				return
@@ -55,12 +143,113 @@ func NewSignals(signalsAndActions map[os.Signal]GracefulAction) *Signals {
 	return s
 }
 
+// control is what the ServiceManager is told to do about sig
+func (s *Signals) control(sig os.Signal, handler SignalHandler) SignalControl {
+	return func(manager *ServiceManager) GracefulAction {
+		if handler.Callback == nil {
+			return handler.Action
+		}
+		s.call(sig, handler.Callback, manager)
+		return GracefulNone
+	}
+}
+
+// SetEscalation configures how stop signals received while stopping force the stop. DefaultSignals forces it on the
+// second one, NewSignals does not escalate unless told to
+func (s *Signals) SetEscalation(e Escalation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.escalation = e
+}
+
+// managedBy remembers the ServiceManager we were added to, as forcing the stop can't wait for Wait to listen to us
+func (s *Signals) managedBy(manager *ServiceManager) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.manager = manager
+}
+
+// stopReceived counts the stop signal sig, and forces the stop once there were enough of them
+// @return true if sig should be handed to the ServiceManager, only the first one is, unless escalation is disabled
+func (s *Signals) stopReceived(sig os.Signal) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.escalation.Count < 2 {
+		return true
+	}
+	now := time.Now()
+	recent := s.stops[:0]
+	for _, at := range s.stops {
+		if s.escalation.Window <= 0 || now.Sub(at) < s.escalation.Window {
+			recent = append(recent, at)
+		}
+	}
+	s.stops = append(recent, now)
+
+	remaining := s.escalation.Count - len(s.stops)
+	if remaining > 0 {
+		s.tell(sig, escalationMessage(sig, remaining))
+		first := !s.stopping
+		s.stopping = true
+		return first
+	}
+	s.tell(sig, "gracefully: forcing stop")
+	if s.manager != nil {
+		s.manager.ForceStop()
+	}
+	// In case nothing told the ServiceManager to stop yet, such as when Wait was never listening
+	first := !s.stopping
+	s.stopping = true
+	return first
+}
+
+// tell writes message to the escalation output, and reports it as an event. Caller must hold mu
+func (s *Signals) tell(sig os.Signal, message string) {
+	if s.escalation.Output != nil {
+		_, _ = fmt.Fprintln(s.escalation.Output, message)
+	}
+	if s.onEvent != nil {
+		s.onEvent(Event{Source: "signals", Signal: sig, Message: message})
+	}
+}
+
+// escalationMessage tells how many more stop signals force the stop
+func escalationMessage(sig os.Signal, remaining int) string {
+	again := "again"
+	if remaining > 1 {
+		again = fmt.Sprintf("%d more times", remaining)
+	}
+	if sig == os.Interrupt {
+		return "gracefully: stopping, press Ctrl-C " + again + " to force"
+	}
+	return fmt.Sprintf("gracefully: stopping on %v, send it %s to force", sig, again)
+}
+
+// call calls the callback handling sig, and reports it if it fails or panics
+func (s *Signals) call(sig os.Signal, callback func(manager *ServiceManager) error, manager *ServiceManager) {
+	var err error
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("gracefully: signal handler panicked: %v", r)
+			}
+		}()
+		err = callback(manager)
+	}()
+	if err != nil && s.onEvent != nil {
+		s.onEvent(Event{Source: "signals", Signal: sig, Message: "signal handler failed", Err: err})
+	}
+}
+
 // DefaultSignals creates a new Signals SignalSelecter pre-configured with:
 // SIGHUP = GracefulRestart
 // SIGINT = GracefulStop
 // SIGTERM = GracefulStop
+// A second SIGINT or SIGTERM while stopping forces the stop, see Escalation. The first one tells the user so on stderr
 func DefaultSignals() *Signals {
-	return NewSignals(defaultSignals)
+	s := NewSignals(defaultSignals)
+	s.SetEscalation(defaultEscalation)
+	return s
 }
 
 // defaultSignals specifies a map of the default actions most services take when a signal arrives
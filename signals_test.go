@@ -0,0 +1,223 @@
+//go:build !windows
+// +build !windows
+
+package gracefully
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSignalHandlers_CallbacksWithoutAction(t *testing.T) {
+	events := make(chan Event, 10)
+	called := make(chan *ServiceManager, 10)
+	sigs := NewSignalHandlers(map[os.Signal]SignalHandler{
+		syscall.SIGUSR1: {Callback: func(manager *ServiceManager) error {
+			called <- manager
+			return errors.New("failed")
+		}},
+		syscall.SIGUSR2: {Callback: func(manager *ServiceManager) error {
+			panic("oops")
+		}},
+	}, func(e Event) { events <- e })
+
+	sm := New()
+	sm.AddSignaler(sigs)
+	cs := NewContextSignal()
+	sm.AddSignaler(cs)
+	iterations := 0
+	sm.Start(func(iCtx context.Context) error {
+		iterations++
+		<-iCtx.Done()
+		return nil
+	})
+	// Signals are handled from Wait
+	done := make(chan error, 1)
+	go func() {
+		done <- sm.Wait()
+	}()
+
+	for _, sig := range []syscall.Signal{syscall.SIGUSR1, syscall.SIGUSR2} {
+		if err := syscall.Kill(os.Getpid(), sig); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case e := <-events:
+			if e.Signal != sig || e.Err == nil {
+				t.Error("expected the failure to be reported, got: ", e)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected an event for ", sig)
+		}
+	}
+	if manager := <-called; manager != sm {
+		t.Error("expected the callback to get the ServiceManager")
+	}
+	if sm.State() != StateRunning {
+		t.Error("expected the ServiceManager to keep running, got: ", sm.State())
+	}
+
+	cs.Stop()
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+	if iterations != 1 {
+		t.Error("expected the routine to run once, got: ", iterations)
+	}
+}
+
+func TestSignalHandlers_Action(t *testing.T) {
+	sigs := NewSignalHandlers(map[os.Signal]SignalHandler{
+		syscall.SIGUSR1: {Action: GracefulStop},
+	}, nil)
+	defer sigs.Cancel()
+	if err := syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+	expectAction(t, sigs, GracefulStop)
+}
+
+// chanWriter sends what is written to it, one write at a time
+type chanWriter chan string
+
+func (w chanWriter) Write(p []byte) (int, error) {
+	w <- string(p)
+	return len(p), nil
+}
+
+func TestSignals_SecondStopForces(t *testing.T) {
+	output := make(chanWriter, 10)
+	sigs := NewSignals(map[os.Signal]GracefulAction{syscall.SIGUSR1: GracefulStop})
+	sigs.SetEscalation(Escalation{Count: 2, Output: output})
+	sm := New()
+	sm.AddSignaler(sigs)
+	// A routine that does not stop when told to
+	release := make(chan struct{})
+	defer close(release)
+	sm.Start(func(iCtx context.Context) error {
+		<-release
+		return nil
+	})
+	done := make(chan error, 1)
+	go func() {
+		done <- sm.Wait()
+	}()
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case message := <-output:
+		if !strings.Contains(message, "again to force") {
+			t.Error("expected to be told how to force the stop, got: ", message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected to be told how to force the stop")
+	}
+	select {
+	case err := <-done:
+		t.Fatal("expected Wait to wait for the routine, got: ", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrAbandoned) {
+			t.Error("expected ErrAbandoned, got: ", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the second stop signal to force the stop")
+	}
+	if sm.State() != StateDead {
+		t.Error("expected StateDead, got: ", sm.State())
+	}
+}
+
+func TestSignals_EscalatesWhileDrainingForRestart(t *testing.T) {
+	sigs := NewSignals(map[os.Signal]GracefulAction{syscall.SIGUSR1: GracefulStop, syscall.SIGUSR2: GracefulRestart})
+	sigs.SetEscalation(Escalation{Count: 2})
+	sm := New()
+	sm.AddSignaler(sigs)
+	// Work that never finishes, the restart drains forever
+	tracked := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	sm.Start(func(iCtx context.Context) error {
+		if _, err := Track(iCtx); err != nil {
+			return err
+		}
+		close(tracked)
+		<-release
+		return nil
+	})
+	done := make(chan error, 1)
+	go func() {
+		done <- sm.Wait()
+	}()
+	<-tracked
+
+	if err := syscall.Kill(os.Getpid(), syscall.SIGUSR2); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for sm.State() != StateRestarting {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the restart to drain, got: ", sm.State())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// Wait is draining, nobody is listening: another restart fills what is handed over, then the first stop signal is
+	// left waiting, the second one must still be counted
+	if err := syscall.Kill(os.Getpid(), syscall.SIGUSR2); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		if err := syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrAbandoned) {
+			t.Error("expected ErrAbandoned, got: ", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the second stop signal to force the stop while draining")
+	}
+}
+
+func TestSignals_EscalationWindow(t *testing.T) {
+	sigs := NewSignals(map[os.Signal]GracefulAction{syscall.SIGUSR1: GracefulStop})
+	defer sigs.Cancel()
+	sigs.SetEscalation(Escalation{Count: 2, Window: 20 * time.Millisecond})
+	sm := New()
+	sm.AddSignaler(sigs)
+	if !sigs.stopReceived(syscall.SIGUSR1) {
+		t.Error("expected the first stop signal to be handed to the ServiceManager")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if sigs.stopReceived(syscall.SIGUSR1) {
+		t.Error("expected repeats not to be handed to the ServiceManager")
+	}
+	select {
+	case <-sm.forced:
+		t.Error("expected stop signals too far apart not to force the stop")
+	default:
+	}
+	sigs.stopReceived(syscall.SIGUSR1)
+	select {
+	case <-sm.forced:
+	default:
+		t.Error("expected stop signals close together to force the stop")
+	}
+}
@@ -0,0 +1,335 @@
+package gracefully
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ErrSpoolCorrupt is wrapped by the error returned from Spool.Replay when a record in the middle of the file failed its checksum.
+// The records before it are replayed and kept, the damaged file is copied aside with a ".corrupt" suffix so it can be
+// inspected, and what follows the damage is dropped
+var ErrSpoolCorrupt = errors.New("gracefully: spool file is corrupt")
+
+// spoolHeaderSize is the size of the header in front of each record: the record length followed by its CRC-32C
+const spoolHeaderSize = 8
+
+const (
+	// spoolItem starts a record holding an item, followed by its encoded payload
+	spoolItem byte = 1
+	// spoolDone starts a record marking the items before it as done: how many of the items in the file, from the start,
+	// are done, as a big endian uint64
+	spoolDone byte = 2
+)
+
+// spoolTable is the CRC-32 table used for record checksums
+var spoolTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Codec turns the items stored in a Spool to and from bytes
+type Codec interface {
+	Encode(item interface{}) ([]byte, error)
+	Decode(data []byte) (interface{}, error)
+}
+
+// Spool is an append-only file of items that could not be processed before the process stopped, so that they can be
+// processed the next time it starts. Each record is checksummed and the file is synced to disk after each Write, a
+// record that was only partially written when the process crashed is ignored.
+// Replayed items stay in the file until Replace says what became of them, so a crash in between replays them again:
+// items are handled at least once, not exactly once. The file is only ever appended to, cut back to its last intact
+// record after a crash, and removed once there is nothing left in it to replay.
+// Pass a Spool in PoolOptions to have a Pool write its leftover jobs to it and replay them before accepting new ones.
+//
+// Do not instantiate yourself, call: NewSpool
+type Spool struct {
+	path  string
+	codec Codec
+	// mu serializes access to the file, and protects replayed and onEvent
+	mu sync.Mutex
+	// replayed is how many records at the start of the file were handed to Replay's fn, and are skipped by the next Replay
+	replayed int
+	// onEvent, if set, is told about records that were dropped
+	onEvent EventHandler
+}
+
+// NewSpool creates a Spool stored at path, using codec to turn items into bytes. The file is only created once something is written to it
+func NewSpool(path string, codec Codec) *Spool {
+	return &Spool{
+		path:  path,
+		codec: codec,
+	}
+}
+
+// SetOnEvent configures who is told when Replay drops the end of the file, either torn by a crash or damaged
+func (s *Spool) SetOnEvent(onEvent EventHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onEvent = onEvent
+}
+
+// Write appends items to the spool file and syncs it to disk
+func (s *Spool) Write(items []interface{}) error {
+	if len(items) == 0 {
+		return nil
+	}
+	payloads, err := s.encode(items)
+	if err != nil {
+		return err
+	}
+	records := make([][]byte, 0, len(payloads))
+	for _, payload := range payloads {
+		records = append(records, append([]byte{spoolItem}, payload...))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.append(records)
+}
+
+// writeRecords writes each record with its header to f, then syncs it
+func writeRecords(f *os.File, records [][]byte) error {
+	w := bufio.NewWriter(f)
+	header := make([]byte, spoolHeaderSize)
+	for _, record := range records {
+		binary.BigEndian.PutUint32(header[0:4], uint32(len(record)))
+		binary.BigEndian.PutUint32(header[4:8], crc32.Checksum(record, spoolTable))
+		// bufio.Writer remembers the first error, Flush will report it
+		_, _ = w.Write(header)
+		_, _ = w.Write(record)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// Replay calls fn with each item in the spool that was not handed to it yet, in the order they were written. If fn
+// returns an error, replay stops, and the next Replay starts again with that item. The items remain in the file until
+// Replace is called once they are done with
+func (s *Spool) Replay(fn func(item interface{}) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	items, done, err := s.load()
+	if err != nil && !errors.Is(err, ErrSpoolCorrupt) {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if s.replayed < done {
+		s.replayed = done
+	}
+	for ; s.replayed < len(items); s.replayed++ {
+		item, decodeErr := s.codec.Decode(items[s.replayed])
+		if decodeErr == nil {
+			decodeErr = fn(item)
+		}
+		if decodeErr != nil {
+			return decodeErr
+		}
+	}
+	// What could be read was replayed, the damage is still worth knowing about
+	return err
+}
+
+// Replace marks the items that were handed to Replay's fn as done, and appends items, such as the ones that are still
+// not done, so that only they and the items not replayed yet are replayed next time. Nothing is rewritten: the mark is
+// a record appended after items, a crash in between replays them twice rather than losing them. The file is removed
+// once nothing is left to replay in it
+func (s *Spool) Replace(items []interface{}) error {
+	payloads, err := s.encode(items)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	written, done, err := s.load()
+	if err != nil && !os.IsNotExist(err) && !errors.Is(err, ErrSpoolCorrupt) {
+		return err
+	}
+	if s.replayed < done {
+		s.replayed = done
+	}
+	if s.replayed >= len(written) && len(payloads) == 0 {
+		s.replayed = 0
+		if err = os.Remove(s.path); err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		return syncDir(filepath.Dir(s.path))
+	}
+	records := make([][]byte, 0, len(payloads)+1)
+	for _, payload := range payloads {
+		records = append(records, append([]byte{spoolItem}, payload...))
+	}
+	if s.replayed > done {
+		mark := make([]byte, 9)
+		mark[0] = spoolDone
+		binary.BigEndian.PutUint64(mark[1:], uint64(s.replayed))
+		records = append(records, mark)
+	}
+	return s.append(records)
+}
+
+// encode turns items into record payloads
+func (s *Spool) encode(items []interface{}) ([][]byte, error) {
+	payloads := make([][]byte, 0, len(items))
+	for _, item := range items {
+		payload, err := s.codec.Encode(item)
+		if err != nil {
+			return nil, err
+		}
+		payloads = append(payloads, payload)
+	}
+	return payloads, nil
+}
+
+// emit reports the event, if anyone is listening. Caller must hold mu
+func (s *Spool) emit(e Event) {
+	if s.onEvent != nil {
+		s.onEvent(e)
+	}
+}
+
+// load reads the items in the spool file, and how many of them are done. What can't be read is cut off, so that what is
+// appended next can be read back: a record torn by a crash is reported, a damaged file is copied aside first, and
+// ErrSpoolCorrupt returned along with the records before the damage. Caller must hold mu
+func (s *Spool) load() (items [][]byte, done int, err error) {
+	records, intact, corrupt, err := readRecords(s.path)
+	if err != nil {
+		return nil, 0, err
+	}
+	if corrupt {
+		// Keep the evidence
+		if err = copyFile(s.path, s.path+".corrupt"); err != nil {
+			return nil, 0, err
+		}
+	}
+	if intact >= 0 {
+		if !corrupt {
+			s.emit(Event{Source: "spool", Message: "dropped the end of " + s.path + ", torn by a crash or damaged"})
+		}
+		if err = truncateFile(s.path, intact); err != nil {
+			return nil, 0, err
+		}
+	}
+	for _, record := range records {
+		switch {
+		case record[0] == spoolItem:
+			items = append(items, record[1:])
+		case record[0] == spoolDone && len(record) == 9:
+			if n := int(binary.BigEndian.Uint64(record[1:])); n > done {
+				done = n
+			}
+		}
+	}
+	if done > len(items) {
+		done = len(items)
+	}
+	if corrupt {
+		err = fmt.Errorf("%w: records after the first %d were dropped, see %s.corrupt", ErrSpoolCorrupt, len(records), s.path)
+	}
+	return items, done, err
+}
+
+// readRecords reads all intact records from the file at path. If anything follows the last one that could be read,
+// intact is the size of the file up to there, -1 otherwise. corrupt is true if a record with a bad checksum was found
+// before the end
+func readRecords(path string) (records [][]byte, intact int64, corrupt bool, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, -1, false, err
+	}
+	defer func() { _ = f.Close() }()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, -1, false, err
+	}
+	size := info.Size()
+	var offset int64
+	r := bufio.NewReader(f)
+	header := make([]byte, spoolHeaderSize)
+	for {
+		remaining := size - offset
+		if remaining == 0 {
+			return records, -1, false, nil
+		}
+		if remaining < spoolHeaderSize {
+			// A torn header, there's nothing more to read
+			return records, offset, false, nil
+		}
+		if _, err = io.ReadFull(r, header); err != nil {
+			return records, -1, false, err
+		}
+		length := int64(binary.BigEndian.Uint32(header[0:4]))
+		if length == 0 || length > remaining-spoolHeaderSize {
+			// Torn payload from a crash mid-write, or a damaged length. We can't tell which, but can't read further either
+			return records, offset, false, nil
+		}
+		record := make([]byte, length)
+		if _, err = io.ReadFull(r, record); err != nil {
+			return records, -1, false, err
+		}
+		if crc32.Checksum(record, spoolTable) != binary.BigEndian.Uint32(header[4:8]) {
+			// A torn last record is expected after a crash, anything else means the file was damaged
+			return records, offset, remaining > spoolHeaderSize+length, nil
+		}
+		offset += spoolHeaderSize + length
+		records = append(records, record)
+	}
+}
+
+// append appends records to the spool file and syncs it. Caller must hold mu
+func (s *Spool) append(records [][]byte) error {
+	_, statErr := os.Stat(s.path)
+	created := os.IsNotExist(statErr)
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if err = writeRecords(f, records); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if created {
+		// Make sure the new file itself survives a crash, not just its contents
+		return syncDir(filepath.Dir(s.path))
+	}
+	return nil
+}
+
+// truncateFile cuts the file at path down to size, and syncs it
+func truncateFile(path string, size int64) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	err = f.Truncate(size)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// copyFile copies the file at from to a new file at to, replacing it if it exists
+func copyFile(from, to string) error {
+	data, err := os.ReadFile(from)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(to, data, 0600)
+}
@@ -0,0 +1,279 @@
+package gracefully
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// intCodec stores ints as decimal strings
+type intCodec struct{}
+
+func (intCodec) Encode(item interface{}) ([]byte, error) {
+	return []byte(strconv.Itoa(item.(int))), nil
+}
+
+func (intCodec) Decode(data []byte) (interface{}, error) {
+	return strconv.Atoi(string(data))
+}
+
+// replayAll replays spool, collecting the items
+func replayAll(t *testing.T, spool *Spool) []interface{} {
+	t.Helper()
+	replayed := make([]interface{}, 0, 3)
+	if err := spool.Replay(func(item interface{}) error {
+		replayed = append(replayed, item)
+		return nil
+	}); err != nil {
+		t.Error(err)
+	}
+	return replayed
+}
+
+func TestSpool_WriteReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spool")
+	spool := NewSpool(path, intCodec{})
+	var events []Event
+	spool.SetOnEvent(func(e Event) { events = append(events, e) })
+	if err := spool.Write([]interface{}{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := spool.Write([]interface{}{3}); err != nil {
+		t.Fatal(err)
+	}
+	// Simulate a crash in the middle of writing a record
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.Write([]byte{0, 0, 0, 9, 1, 2})
+	_ = f.Close()
+
+	replayed := make([]interface{}, 0, 3)
+	err = spool.Replay(func(item interface{}) error {
+		if len(replayed) == 2 {
+			return errors.New("not now")
+		}
+		replayed = append(replayed, item)
+		return nil
+	})
+	if err == nil {
+		t.Error("expected the error from fn")
+	}
+	if len(replayed) != 2 || replayed[0] != 1 || replayed[1] != 2 {
+		t.Error("expected 1 and 2 to be replayed, got: ", replayed)
+	}
+	if len(events) != 1 {
+		t.Error("expected the torn record to be reported, got: ", events)
+	}
+
+	// Written after the torn record was dropped, so it can be read back
+	if err = spool.Write([]interface{}{4}); err != nil {
+		t.Fatal(err)
+	}
+	// Replay goes on with the item that was refused
+	if replayed = replayAll(t, spool); len(replayed) != 2 || replayed[0] != 3 || replayed[1] != 4 {
+		t.Error("expected 3 and 4 to be replayed, got: ", replayed)
+	}
+	if _, err = os.Stat(path); err != nil {
+		t.Error("expected the spool file to be kept until Replace: ", err)
+	}
+	if err = spool.Replace(nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Error("expected spool file to be removed once nothing is left")
+	}
+}
+
+func TestSpool_CrashAfterReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spool")
+	spool := NewSpool(path, intCodec{})
+	if err := spool.Write([]interface{}{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	replayAll(t, spool)
+	// The process died before the items were done, the next one gets them again
+	if replayed := replayAll(t, NewSpool(path, intCodec{})); len(replayed) != 2 || replayed[0] != 1 || replayed[1] != 2 {
+		t.Error("expected 1 and 2 to be replayed again, got: ", replayed)
+	}
+}
+
+func TestSpool_ReplaceAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spool")
+	spool := NewSpool(path, intCodec{})
+	if err := spool.Write([]interface{}{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	// Only 1 and 2 are replayed, and 2 is not done
+	err := spool.Replay(func(item interface{}) error {
+		if item == 3 {
+			return errors.New("not now")
+		}
+		return nil
+	})
+	if err == nil {
+		t.Error("expected the error from fn")
+	}
+	if err = spool.Replace([]interface{}{2}); err != nil {
+		t.Fatal(err)
+	}
+	// 3 was never replayed, 2 was handed back after it
+	if replayed := replayAll(t, NewSpool(path, intCodec{})); len(replayed) != 2 || replayed[0] != 3 || replayed[1] != 2 {
+		t.Error("expected 3 then 2, got: ", replayed)
+	}
+	// Nothing was rewritten: the three items, 2 again and the mark for 1 and 2
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := 5*spoolHeaderSize + 4*2 + 9; len(data) != expected {
+		t.Error("expected the spool to be appended to, size: ", len(data), " expected: ", expected)
+	}
+}
+
+func TestSpool_CorruptRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spool")
+	spool := NewSpool(path, intCodec{})
+	if err := spool.Write([]interface{}{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	// Flip a byte of the second record's payload, each record is its kind followed by a one byte item
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[spoolHeaderSize+2+spoolHeaderSize+1] ^= 0xff
+	if err = os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	replayed := make([]interface{}, 0, 1)
+	err = spool.Replay(func(item interface{}) error {
+		replayed = append(replayed, item)
+		return nil
+	})
+	if !errors.Is(err, ErrSpoolCorrupt) {
+		t.Error("expected ErrSpoolCorrupt, got: ", err)
+	}
+	if len(replayed) != 1 || replayed[0] != 1 {
+		t.Error("expected only 1 to be replayed, got: ", replayed)
+	}
+	if _, err = os.Stat(path + ".corrupt"); err != nil {
+		t.Error("expected corrupt file to be kept: ", err)
+	}
+}
+
+func TestPool_SpoolsLeftoverAndReplays(t *testing.T) {
+	spool := NewSpool(filepath.Join(t.TempDir(), "spool"), intCodec{})
+	started := make(chan bool, 1)
+	pool := NewPool(PoolOptions{
+		QueueSize:    3,
+		DrainTimeout: time.Second / 20,
+		Spool:        spool,
+		Handler: func(ctx context.Context, job interface{}) {
+			started <- true
+			<-ctx.Done()
+		},
+	})
+	sm := New()
+	cs := NewContextSignal()
+	sm.AddSignaler(cs)
+	go func() {
+		// Submit waits for the spool to be replayed, which happens once the pool runs
+		for i := 0; i < 3; i++ {
+			if err := pool.Submit(context.Background(), i); err != nil {
+				t.Error(err)
+			}
+		}
+		<-started
+		cs.Stop()
+	}()
+	if err := sm.Run(pool.Run); err != nil {
+		t.Fatal(err)
+	}
+	if len(pool.Leftover()) != 0 {
+		t.Error("expected leftover to be spooled, got: ", pool.Leftover())
+	}
+
+	// Next start: the spooled jobs must be handled before the new one
+	handled := make(chan interface{}, 3)
+	pool = NewPool(PoolOptions{
+		Spool: spool,
+		Handler: func(ctx context.Context, job interface{}) {
+			handled <- job
+		},
+	})
+	sm = New()
+	cs = NewContextSignal()
+	sm.AddSignaler(cs)
+	go func() {
+		_ = pool.Submit(context.Background(), 3)
+		for i := 1; i <= 3; i++ {
+			if job := <-handled; job != i {
+				t.Error("expected job ", i, " got: ", job)
+			}
+		}
+		cs.Stop()
+	}()
+	if err := sm.Run(pool.Run); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(spool.path); !os.IsNotExist(err) {
+		t.Error("expected spool file to be removed once its jobs were handled")
+	}
+}
+
+func TestPool_CorruptSpoolKeepsRunning(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spool")
+	spool := NewSpool(path, intCodec{})
+	if err := spool.Write([]interface{}{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	// Damage the first record, so nothing can be replayed
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[spoolHeaderSize+1] ^= 0xff
+	if err = os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	events := make(chan Event, 1)
+	handled := make(chan interface{}, 1)
+	pool := NewPool(PoolOptions{
+		Spool:   spool,
+		OnEvent: func(e Event) { events <- e },
+		Handler: func(ctx context.Context, job interface{}) {
+			handled <- job
+		},
+	})
+	sm := New()
+	cs := NewContextSignal()
+	sm.AddSignaler(cs)
+	go func() {
+		if err := pool.Submit(context.Background(), 3); err != nil {
+			t.Error(err)
+		}
+		if job := <-handled; job != 3 {
+			t.Error("expected the submitted job to be handled, got: ", job)
+		}
+		cs.Stop()
+	}()
+	if err = sm.Run(pool.Run); err != nil {
+		t.Error("expected the pool to keep running, got: ", err)
+	}
+	select {
+	case e := <-events:
+		if !errors.Is(e.Err, ErrSpoolCorrupt) {
+			t.Error("expected ErrSpoolCorrupt to be reported, got: ", e.Err)
+		}
+	default:
+		t.Error("expected the corrupt spool to be reported")
+	}
+}
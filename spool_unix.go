@@ -0,0 +1,16 @@
+//go:build !windows
+// +build !windows
+
+package gracefully
+
+import "os"
+
+// syncDir syncs a directory so that files created, renamed or removed in it survive a crash
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = d.Close() }()
+	return d.Sync()
+}
@@ -0,0 +1,9 @@
+//go:build windows
+// +build windows
+
+package gracefully
+
+// syncDir does nothing, directories can't be opened to be synced on windows
+func syncDir(dir string) error {
+	return nil
+}
@@ -0,0 +1,132 @@
+package gracefully
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// StdinClosedOptions configures a StdinClosed
+type StdinClosedOptions struct {
+	// Commands maps lines read, without surrounding spaces, to what the ServiceManager should do, see StdinCommands.
+	// Other lines are ignored. If nil, everything read is ignored, only the end of the stream matters
+	Commands map[string]GracefulAction
+	// OnEvent, if set, is told about read errors other than EOF, which are otherwise handled like EOF
+	OnEvent EventHandler
+}
+
+// StdinCommands are the commands most tools would accept: "restart" and "stop"
+func StdinCommands() map[string]GracefulAction {
+	return map[string]GracefulAction{
+		"restart": GracefulRestart,
+		"stop":    GracefulStop,
+	}
+}
+
+// readDeadliner is implemented by readers whose blocked Read can be interrupted, such as pollable os.File and net.Conn
+type readDeadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
+// StdinClosed is a SignalSelecter that tells the ServiceManager what to do once its standard input, or any io.Reader,
+// reaches EOF. This is how supervisors that hold the other end of a pipe tell us to go away.
+//
+// Cancel interrupts a Read in progress if the reader supports deadlines. Standard input does on linux once it's a pipe
+// or terminal, otherwise the goroutine ends with the next Read.
+// Do not instantiate yourself, call: NewStdinClosed or NewReaderClosed
+type StdinClosed struct {
+	BaseSignaler
+	// action is what the ServiceManager should do on EOF
+	action GracefulAction
+	// reader is what's read, it supports deadlines if interrupt is not nil
+	reader io.Reader
+	// interrupt unblocks the Read in progress, nil if it can't be done
+	interrupt func()
+	// release cleans up once done reading, nil if there is nothing to clean up
+	release func()
+}
+
+// NewStdinClosed creates a StdinClosed that issues action once standard input is closed
+func NewStdinClosed(action GracefulAction, opts StdinClosedOptions) *StdinClosed {
+	return NewReaderClosed(os.Stdin, action, opts)
+}
+
+// NewReaderClosed creates a StdinClosed that issues action once r reaches EOF
+func NewReaderClosed(r io.Reader, action GracefulAction, opts StdinClosedOptions) *StdinClosed {
+	s := &StdinClosed{
+		BaseSignaler: NewBaseSignaler(),
+		action:       action,
+		reader:       r,
+	}
+	if f, ok := r.(*os.File); ok {
+		// Reads from a file can only be interrupted if the runtime poller knows about it
+		if pollable, release, err := pollableFile(f); err == nil {
+			s.reader, s.release = pollable, release
+		}
+	}
+	if d, ok := s.reader.(readDeadliner); ok {
+		s.interrupt = func() {
+			_ = d.SetReadDeadline(time.Unix(1, 0))
+		}
+		if s.release == nil {
+			// It's the caller's, leave it the way we found it
+			s.release = func() {
+				_ = d.SetReadDeadline(time.Time{})
+			}
+		}
+	}
+	go s.read(opts)
+	return s
+}
+
+// Cancel stops reading, interrupting the Read in progress if possible
+func (s *StdinClosed) Cancel() {
+	if s.cancel() && s.interrupt != nil {
+		s.interrupt()
+	}
+}
+
+// read reads lines until EOF or until cancelled
+func (s *StdinClosed) read(opts StdinClosedOptions) {
+	if s.release != nil {
+		defer s.release()
+	}
+	br := bufio.NewReader(s.reader)
+	// tooLong is true while skipping the rest of a line that did not fit in the buffer, it's no command we know
+	tooLong := false
+	for {
+		line, err := br.ReadSlice('\n')
+		switch {
+		case err == bufio.ErrBufferFull:
+			tooLong = true
+			continue
+		case tooLong:
+			tooLong = false
+		case opts.Commands != nil:
+			if action, ok := opts.Commands[strings.TrimSpace(string(line))]; ok && !s.sendAction(action) {
+				return
+			}
+		}
+		if err == nil {
+			continue
+		}
+		select {
+		case <-s.cancelled:
+			// The error is most likely our own deadline
+			return
+		default:
+		}
+		if err != io.EOF && opts.OnEvent != nil {
+			opts.OnEvent(Event{Source: "stdin", Message: "unable to read, handling as closed", Err: err})
+		}
+		// The writer is gone, so is our reason to exist
+		s.sendAction(s.action)
+		return
+	}
+}
+
+// errNotPollable is returned by pollableFile when a file can't be made to support deadlines
+var errNotPollable = errors.New("gracefully: file does not support deadlines")
@@ -0,0 +1,45 @@
+package gracefully
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// pollableFile gets a copy of f that supports deadlines, and how to release it once done reading.
+// os.Stdin is blocking, and making it non-blocking would change it for whoever else holds the same pipe or terminal,
+// such as our children. So it's opened again through /proc, which makes a copy with blocking mode of its own.
+// Sockets can't be opened that way
+func pollableFile(f *os.File) (*os.File, func(), error) {
+	if err := f.SetReadDeadline(time.Time{}); err == nil {
+		return f, nil, nil
+	}
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return nil, nil, err
+	}
+	var path string
+	var stat syscall.Stat_t
+	var statErr error
+	if err = conn.Control(func(fd uintptr) {
+		path = "/proc/self/fd/" + strconv.Itoa(int(fd))
+		statErr = syscall.Fstat(int(fd), &stat)
+	}); err != nil {
+		return nil, nil, err
+	}
+	if statErr != nil || stat.Mode&syscall.S_IFMT == syscall.S_IFREG || stat.Mode&syscall.S_IFMT == syscall.S_IFDIR {
+		// Regular files are always ready, the poller won't have them
+		return nil, nil, errNotPollable
+	}
+	// Opened non-blocking, os.OpenFile registers it with the poller
+	pollable, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = pollable.SetReadDeadline(time.Time{}); err != nil {
+		_ = pollable.Close()
+		return nil, nil, errNotPollable
+	}
+	return pollable, func() { _ = pollable.Close() }, nil
+}
@@ -0,0 +1,60 @@
+package gracefully
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestStdinClosed_CancelInterruptsBlockingRead(t *testing.T) {
+	// Like most standard inputs, this pipe is blocking, the runtime poller knows nothing about it
+	var fds [2]int
+	if err := syscall.Pipe(fds[:]); err != nil {
+		t.Fatal(err)
+	}
+	r := os.NewFile(uintptr(fds[0]), "stdin")
+	w := os.NewFile(uintptr(fds[1]), "writer")
+	defer r.Close()
+	defer w.Close()
+
+	s := NewReaderClosed(r, GracefulStop, StdinClosedOptions{Commands: StdinCommands()})
+	time.Sleep(10 * time.Millisecond)
+	// Whoever else uses the pipe expects it blocking, even while we read
+	if isNonBlocking(r) {
+		t.Error("expected the pipe to stay blocking")
+	}
+	s.Cancel()
+	time.Sleep(10 * time.Millisecond)
+
+	// Had the read still been blocked, it would take this from us
+	_, _ = w.Write([]byte("stop\n"))
+	got := make(chan string, 1)
+	go func() {
+		buf := make([]byte, 16)
+		n, _ := r.Read(buf)
+		got <- string(buf[:n])
+	}()
+	select {
+	case line := <-got:
+		if line != "stop\n" {
+			t.Error("unexpected read: ", line)
+		}
+	case <-time.After(time.Second):
+		t.Error("expected the read to be interrupted on cancel")
+	}
+	if isNonBlocking(r) {
+		t.Error("expected the pipe to stay blocking")
+	}
+}
+
+// isNonBlocking is true if f is in non-blocking mode
+func isNonBlocking(f *os.File) bool {
+	var nonBlocking bool
+	conn, _ := f.SyscallConn()
+	_ = conn.Control(func(fd uintptr) {
+		flags, _, _ := syscall.Syscall(syscall.SYS_FCNTL, fd, syscall.F_GETFL, 0)
+		nonBlocking = flags&syscall.O_NONBLOCK != 0
+	})
+	return nonBlocking
+}
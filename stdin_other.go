@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package gracefully
+
+import (
+	"os"
+	"time"
+)
+
+// pollableFile gets f if it supports deadlines. There is nothing we can do about it otherwise: making it non-blocking
+// would change it for whoever else holds the same pipe or terminal, and there is no /proc to open a copy from
+func pollableFile(f *os.File) (*os.File, func(), error) {
+	if err := f.SetReadDeadline(time.Time{}); err != nil {
+		return nil, nil, errNotPollable
+	}
+	return f, nil, nil
+}
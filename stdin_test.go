@@ -0,0 +1,24 @@
+//go:build !windows
+// +build !windows
+
+package gracefully
+
+import (
+	"os"
+	"testing"
+)
+
+func TestStdinClosed_CommandsThenEOF(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	s := NewReaderClosed(r, GracefulStop, StdinClosedOptions{Commands: StdinCommands()})
+	defer s.Cancel()
+
+	_, _ = w.Write([]byte("hello\n  restart \n"))
+	expectAction(t, s, GracefulRestart)
+	_ = w.Close()
+	expectAction(t, s, GracefulStop)
+}
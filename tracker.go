@@ -0,0 +1,150 @@
+package gracefully
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrDraining is returned by Track once the ServiceManager was asked to stop or restart, no new work should be started
+var ErrDraining = errors.New("gracefully: draining, not accepting new work")
+
+// WorkStats describes the work tracked with Track for a ServiceManager
+type WorkStats struct {
+	// InFlight is how much work the current iteration started and is not done yet. Work of previous iterations that was
+	// never marked done is not counted, it's not waited for either
+	InFlight int
+	// Started is how much work was started since the ServiceManager was created
+	Started uint64
+	// Refused is how much work was refused because the ServiceManager was draining
+	Refused uint64
+	// Draining is true while the ServiceManager is waiting for work to finish before stopping or restarting
+	Draining bool
+}
+
+// workTracker counts the work in flight so that the ServiceManager can wait for it before cancelling the context
+type workTracker struct {
+	// mu protects all fields below, and those of the iterationWork
+	mu    sync.Mutex
+	stats WorkStats
+	// current is the work of the current iteration, the only work drain waits for
+	current *iterationWork
+}
+
+// iterationWork is the work started by a single iteration, so that work that is never done only holds up its own iteration
+type iterationWork struct {
+	inFlight int
+	// idle is closed when draining and nothing is in flight
+	idle chan struct{}
+}
+
+// newWorkTracker creates a workTracker that is accepting work
+func newWorkTracker() *workTracker {
+	return &workTracker{current: &iterationWork{}}
+}
+
+// start records new work for the current iteration, unless draining
+// @return the iteration to hand to done
+func (w *workTracker) start() (*iterationWork, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.stats.Draining {
+		return nil, w.refuseLocked()
+	}
+	w.current.inFlight++
+	w.stats.Started++
+	return w.current, nil
+}
+
+// refuse records work that was not allowed to start
+func (w *workTracker) refuse() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.refuseLocked()
+}
+
+// refuseLocked is refuse for callers already holding mu
+func (w *workTracker) refuseLocked() error {
+	w.stats.Refused++
+	return ErrDraining
+}
+
+// done records work of iteration finishing, waking up drain if it was the last one
+func (w *workTracker) done(iteration *iterationWork) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	iteration.inFlight--
+	if iteration.inFlight == 0 && iteration.idle != nil {
+		close(iteration.idle)
+		iteration.idle = nil
+	}
+}
+
+// drain stops accepting work, then waits for the work in flight to finish, for the timeout to expire, or for abort to be closed
+// @return true if all work finished
+func (w *workTracker) drain(timeout time.Duration, abort <-chan struct{}) bool {
+	w.mu.Lock()
+	w.stats.Draining = true
+	iteration := w.current
+	if iteration.inFlight == 0 {
+		w.mu.Unlock()
+		return true
+	}
+	if iteration.idle == nil {
+		iteration.idle = make(chan struct{})
+	}
+	idle := iteration.idle
+	w.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-idle:
+		return true
+	case <-timer.C:
+		return false
+	case <-abort:
+		return false
+	}
+}
+
+// accept starts accepting work again, for the next iteration. Whatever the previous one did not finish is forgotten
+func (w *workTracker) accept() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.stats.Draining = false
+	w.current = &iterationWork{}
+}
+
+// snapshot gets a copy of the current stats
+func (w *workTracker) snapshot() WorkStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	stats := w.stats
+	stats.InFlight = w.current.inFlight
+	return stats
+}
+
+// Track records the start of some work in the iteration ctx was handed to. Call done once the work is finished.
+// When the ServiceManager is asked to stop or restart, it waits for all tracked work to be done, up to the drain
+// timeout, before cancelling the context. Once that happened, or ctx is done, new work is refused with ErrDraining.
+// If ctx did not come from a ServiceManager, nothing is tracked
+func Track(ctx context.Context) (done func(), err error) {
+	s := managerFromContext(ctx)
+	if s == nil {
+		return func() {}, nil
+	}
+	if ctx.Err() != nil {
+		// This iteration is over, whatever is tracked now would be counted against the next one
+		return func() {}, s.work.refuse()
+	}
+	iteration, err := s.work.start()
+	if err != nil {
+		return func() {}, err
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() { s.work.done(iteration) })
+	}, nil
+}
@@ -0,0 +1,109 @@
+package gracefully
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTrack_DrainsBeforeCancel(t *testing.T) {
+	sm := New()
+	cs := NewContextSignal()
+	sm.AddSignaler(cs)
+	err := sm.Run(func(iCtx context.Context) error {
+		done, err := Track(iCtx)
+		if err != nil {
+			return err
+		}
+		cs.Stop()
+		// Wait until the ServiceManager is draining, new work must be refused
+		for !sm.Work().Draining {
+			time.Sleep(time.Millisecond)
+		}
+		if _, err = Track(iCtx); err != ErrDraining {
+			t.Error("expected ErrDraining, got: ", err)
+		}
+		if iCtx.Err() != nil {
+			t.Error("context cancelled while work was still in flight")
+		}
+		if stats := sm.Work(); stats.InFlight != 1 || stats.Refused != 1 {
+			t.Error("unexpected stats: ", stats)
+		}
+		done()
+		<-iCtx.Done()
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if stats := sm.Work(); stats.InFlight != 0 || stats.Started != 1 {
+		t.Error("unexpected stats: ", stats)
+	}
+}
+
+func TestTrack_DrainTimeout(t *testing.T) {
+	sm := New()
+	sm.SetDrainTimeout(time.Second / 20)
+	cs := NewContextSignal()
+	sm.AddSignaler(cs)
+	err := sm.Run(func(iCtx context.Context) error {
+		if _, err := Track(iCtx); err != nil {
+			return err
+		}
+		cs.Stop()
+		// Never calling done, the context must be cancelled anyway
+		<-iCtx.Done()
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if stats := sm.Work(); stats.InFlight != 1 {
+		t.Error("expected the abandoned work to still be in flight, got: ", stats)
+	}
+}
+
+func TestTrack_LeakedWorkHoldsUpOnlyItsIteration(t *testing.T) {
+	const timeout = time.Second / 5
+	sm := New()
+	sm.SetDrainTimeout(timeout)
+	cs := NewContextSignal()
+	sm.AddSignaler(cs)
+	iterations := 0
+	var laterIterations time.Time
+	err := sm.Run(func(iCtx context.Context) error {
+		iterations++
+		switch iterations {
+		case 1:
+			// Never calling done
+			if _, err := Track(iCtx); err != nil {
+				return err
+			}
+			cs.Restart()
+			// While waiting for the work, we are no longer running
+			time.Sleep(timeout / 4)
+			if state := sm.State(); state != StateRestarting {
+				t.Error("expected StateRestarting while draining, got: ", state)
+			}
+		case 2:
+			laterIterations = time.Now()
+			if stats := sm.Work(); stats.InFlight != 0 {
+				t.Error("expected the leaked work not to count against this iteration, got: ", stats)
+			}
+			cs.Restart()
+		default:
+			cs.Stop()
+		}
+		<-iCtx.Done()
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if iterations != 3 {
+		t.Error("expected 3 iterations, got: ", iterations)
+	}
+	if took := time.Since(laterIterations); took >= timeout {
+		t.Error("expected the later restart and stop not to wait for the leaked work, took: ", took)
+	}
+}
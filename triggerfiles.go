@@ -0,0 +1,175 @@
+package gracefully
+
+import (
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// defaultTriggerPollInterval is how often TriggerFiles looks for its files, if not configured
+const defaultTriggerPollInterval = time.Second
+
+// TriggerFilesOptions configures a TriggerFiles
+type TriggerFilesOptions struct {
+	// Triggers maps files, such as /run/myservice/restart, to what the ServiceManager should do once they show up.
+	// A trigger is removed, or renamed, once acted upon
+	Triggers map[string]GracefulAction
+	// Maintenance, if set, is a file whose presence pauses the ServiceManager, see GracefulPause, and whose removal resumes it
+	Maintenance string
+	// ConsumedSuffix, if set, is appended to the name of a trigger once acted upon, instead of removing it, to keep a trace
+	ConsumedSuffix string
+	// PollInterval is how often the files are looked for, on top of being watched like FileWatch does. Defaults to 1 second
+	PollInterval time.Duration
+	// OnEvent, if set, is told when a trigger could not be removed, in which case it's ignored
+	OnEvent EventHandler
+}
+
+// TriggerFiles is a SignalSelecter that lets operators control the ServiceManager with files, such as
+// touch /run/myservice/restart, without signals or sockets.
+// Do not instantiate yourself, call: NewTriggerFiles
+type TriggerFiles struct {
+	BaseSignaler
+	opts TriggerFilesOptions
+	// triggers are the trigger files, sorted, so that they're looked at in the same order every time
+	triggers []string
+	// mu protects manager
+	mu sync.Mutex
+	// manager is the ServiceManager we were added to, whose state tells whether the maintenance file was honored
+	manager *ServiceManager
+}
+
+// NewTriggerFiles creates a TriggerFiles watching for the files in opts
+func NewTriggerFiles(opts TriggerFilesOptions) *TriggerFiles {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = defaultTriggerPollInterval
+	}
+	t := &TriggerFiles{
+		BaseSignaler: NewBaseSignaler(),
+		opts:         opts,
+		triggers:     make([]string, 0, len(opts.Triggers)),
+	}
+	for path := range opts.Triggers {
+		t.triggers = append(t.triggers, path)
+	}
+	sort.Strings(t.triggers)
+
+	paths := append([]string{}, t.triggers...)
+	if opts.Maintenance != "" {
+		paths = append(paths, opts.Maintenance)
+	}
+	changes := make(chan struct{}, 1)
+	var w watcher
+	if iw, err := newInotifyWatcher(paths, opts.PollInterval, changes); err == nil {
+		w = iw
+	} else {
+		w = newPollWatcher(paths, opts.PollInterval, changes)
+	}
+	go t.run(w, changes)
+	return t
+}
+
+// Cancel stops looking for the files
+func (t *TriggerFiles) Cancel() {
+	t.cancel()
+}
+
+// managedBy remembers the ServiceManager we were added to, a pause it could not take is asked for again
+func (t *TriggerFiles) managedBy(manager *ServiceManager) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.manager = manager
+}
+
+// run looks for the files every time something changed, and every PollInterval, until cancelled
+func (t *TriggerFiles) run(w watcher, changes <-chan struct{}) {
+	defer w.close()
+	ticker := time.NewTicker(t.opts.PollInterval)
+	defer ticker.Stop()
+	// paused is whether we asked for a pause last, for when there is no ServiceManager to ask
+	paused := false
+	// ignored are the triggers that could not be consumed, until they're gone
+	ignored := make(map[string]bool)
+	for {
+		for _, path := range t.triggers {
+			if !exists(path) {
+				delete(ignored, path)
+				continue
+			}
+			if ignored[path] {
+				continue
+			}
+			if !t.consume(path) {
+				ignored[path] = true
+				continue
+			}
+			if !t.sendAction(t.opts.Triggers[path]) {
+				return
+			}
+		}
+		if t.opts.Maintenance != "" {
+			if action, ok := t.maintenanceAction(exists(t.opts.Maintenance), paused); ok {
+				paused = action == GracefulPause
+				if !t.sendAction(action) {
+					return
+				}
+			}
+		}
+
+		select {
+		case <-changes:
+		case <-ticker.C:
+		case <-t.cancelled:
+			return
+		}
+	}
+}
+
+// maintenanceAction tells what to do for the maintenance file being there, or not. The state of the ServiceManager is
+// what counts, as it only pauses while running, such as not while restarting, and the file is looked at again until it did
+// @return false if there is nothing to do
+func (t *TriggerFiles) maintenanceAction(present, paused bool) (GracefulAction, bool) {
+	t.mu.Lock()
+	manager := t.manager
+	t.mu.Unlock()
+	if manager != nil {
+		state := manager.State()
+		paused = state == StatePaused
+		if present && state != StateRunning && !paused {
+			// Busy, it would not be taken now, ask again later
+			return GracefulNone, false
+		}
+	}
+	switch {
+	case present && !paused:
+		return GracefulPause, true
+	case !present && paused:
+		return GracefulResume, true
+	}
+	return GracefulNone, false
+}
+
+// consume removes or renames the trigger, so that it's only acted upon once
+// @return false if that failed, the trigger is then ignored so that it's not acted upon over and over again
+func (t *TriggerFiles) consume(path string) bool {
+	var err error
+	if t.opts.ConsumedSuffix != "" {
+		err = os.Rename(path, path+t.opts.ConsumedSuffix)
+	} else {
+		err = os.Remove(path)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		if t.opts.OnEvent != nil {
+			t.opts.OnEvent(Event{Source: "trigger-files", Message: "unable to consume trigger " + path + ", ignoring it", Err: err})
+		}
+		return false
+	}
+	// Someone else may have removed it first, it was there, so it still counts
+	return true
+}
+
+// exists is true if there is something at path
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
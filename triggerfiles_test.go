@@ -0,0 +1,120 @@
+package gracefully
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTriggerFiles_ConsumesTriggers(t *testing.T) {
+	dir := t.TempDir()
+	restart := filepath.Join(dir, "restart")
+	stop := filepath.Join(dir, "stop")
+	tf := NewTriggerFiles(TriggerFilesOptions{
+		Triggers:       map[string]GracefulAction{restart: GracefulRestart, stop: GracefulStop},
+		ConsumedSuffix: ".done",
+		PollInterval:   10 * time.Millisecond,
+	})
+	defer tf.Cancel()
+
+	if err := os.WriteFile(restart, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	expectAction(t, tf, GracefulRestart)
+	expectNoAction(t, tf, 50*time.Millisecond)
+	if exists(restart) || !exists(restart+".done") {
+		t.Error("expected the trigger to be renamed once consumed")
+	}
+}
+
+func TestTriggerFiles_MaintenancePausesService(t *testing.T) {
+	dir := t.TempDir()
+	maintenance := filepath.Join(dir, "maintenance")
+	stop := filepath.Join(dir, "stop")
+	sm := New()
+	sm.AddSignaler(NewTriggerFiles(TriggerFilesOptions{
+		Triggers:     map[string]GracefulAction{stop: GracefulStop},
+		Maintenance:  maintenance,
+		PollInterval: 10 * time.Millisecond,
+	}))
+	iterations := 0
+	err := sm.Run(func(ctx context.Context) error {
+		iterations++
+		switch iterations {
+		case 1:
+			_ = os.WriteFile(maintenance, nil, 0644)
+		case 2:
+			_ = os.WriteFile(stop, nil, 0644)
+		}
+		<-ctx.Done()
+		if iterations == 1 {
+			go func() {
+				time.Sleep(20 * time.Millisecond)
+				if sm.State() != StatePaused {
+					t.Error("expected the service to be paused, got: ", sm.State())
+				}
+				_ = os.Remove(maintenance)
+			}()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if iterations != 2 {
+		t.Error("expected the service to resume once, got iterations: ", iterations)
+	}
+	if exists(stop) {
+		t.Error("expected the trigger to be removed once consumed")
+	}
+}
+
+func TestTriggerFiles_MaintenanceDuringRestart(t *testing.T) {
+	maintenance := filepath.Join(t.TempDir(), "maintenance")
+	sm := New()
+	cs := NewContextSignal()
+	sm.AddSignaler(cs)
+	sm.AddSignaler(NewTriggerFiles(TriggerFilesOptions{
+		Maintenance:  maintenance,
+		PollInterval: 10 * time.Millisecond,
+	}))
+	iterations := 0
+	err := sm.Run(func(ctx context.Context) error {
+		iterations++
+		switch iterations {
+		case 1:
+			cs.Restart()
+			<-ctx.Done()
+			// Too late for this iteration, the pause is for the next one
+			_ = os.WriteFile(maintenance, nil, 0644)
+			time.Sleep(50 * time.Millisecond)
+		case 2:
+			select {
+			case <-ctx.Done():
+				go func() {
+					time.Sleep(20 * time.Millisecond)
+					if sm.State() != StatePaused {
+						t.Error("expected the service to be paused, got: ", sm.State())
+					}
+					_ = os.Remove(maintenance)
+				}()
+			case <-time.After(5 * time.Second):
+				t.Error("expected the maintenance file to pause the service once restarted")
+				cs.Stop()
+				<-ctx.Done()
+			}
+		default:
+			cs.Stop()
+			<-ctx.Done()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if iterations != 3 {
+		t.Error("expected the service to be paused then resumed, got iterations: ", iterations)
+	}
+}
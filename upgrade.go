@@ -0,0 +1,246 @@
+//go:build !windows
+// +build !windows
+
+package gracefully
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// EnvListeners is the environment variable used to tell the new process which listeners it inherited.
+	// It's a comma-separated list of network:address pairs, the first one being file descriptor 3, the next 4 and so on
+	EnvListeners = "GRACEFULLY_LISTENERS"
+	// EnvReadyFD is the environment variable used to tell the new process which file descriptor to write to once it's ready
+	EnvReadyFD = "GRACEFULLY_READY_FD"
+
+	// firstExtraFD is the file descriptor number of the first entry in exec.Cmd.ExtraFiles
+	firstExtraFD = 3
+	// defaultReadyTimeout is how long we wait for the new process to become ready if ExecUpgraderOptions.ReadyTimeout is not set
+	defaultReadyTimeout = time.Minute
+)
+
+var (
+	// ErrUpgradeInProgress is returned by Upgrade if another upgrade has not finished yet
+	ErrUpgradeInProgress = errors.New("gracefully: upgrade already in progress")
+	// ErrUpgradeTimeout is returned by Upgrade when the new process did not report ready in time. It will have been killed
+	ErrUpgradeTimeout = errors.New("gracefully: new process did not become ready in time")
+	// ErrUpgradeChildExited is returned by Upgrade when the new process exited before it reported ready
+	ErrUpgradeChildExited = errors.New("gracefully: new process exited before becoming ready")
+)
+
+// ExecUpgraderOptions configures an ExecUpgrader
+type ExecUpgraderOptions struct {
+	// ReadyTimeout is how long the new process has to call Ready before it's killed. Defaults to 1 minute
+	ReadyTimeout time.Duration
+	// OnError, if set, is called when an upgrade triggered by a GracefulUpgrade fails. The old process keeps serving
+	OnError func(err error)
+}
+
+// ExecUpgrader is an Upgrader that re-executes the current binary, handing it the open sockets of a Listeners registry.
+// The new process is started with the same arguments, environment and standard files as this one.
+// Once it calls Ready, this process is told to stop gracefully, letting it finish what it was doing.
+//
+// Do not instantiate yourself, call: NewExecUpgrader
+type ExecUpgrader struct {
+	opts ExecUpgraderOptions
+	// listeners are the sockets handed to the new process
+	listeners *Listeners
+	// mu protects all fields below
+	mu sync.Mutex
+	// upgrading is true while a new process is being started, only one upgrade can happen at a time
+	upgrading bool
+	// ready is the pipe to our parent, nil if we were not started by an upgrade or Ready was already called
+	ready *os.File
+}
+
+// NewExecUpgrader creates a new ExecUpgrader that hands over the sockets in listeners, usually ServiceManager.Listeners.
+// If this process was started by an upgrade, the sockets from the parent are added to listeners, ready to be claimed with Listen
+func NewExecUpgrader(listeners *Listeners, opts ExecUpgraderOptions) (u *ExecUpgrader, err error) {
+	if opts.ReadyTimeout <= 0 {
+		opts.ReadyTimeout = defaultReadyTimeout
+	}
+	u = &ExecUpgrader{
+		opts:      opts,
+		listeners: listeners,
+	}
+	err = u.inherit()
+	return
+}
+
+// inherit picks up the listeners and ready pipe passed to us from the parent process, if any
+func (u *ExecUpgrader) inherit() error {
+	if keys := os.Getenv(EnvListeners); keys != "" {
+		for i, key := range strings.Split(keys, ",") {
+			f := os.NewFile(uintptr(firstExtraFD+i), key)
+			l, err := net.FileListener(f)
+			// FileListener dups the file descriptor, we no longer need ours
+			_ = f.Close()
+			if err == nil {
+				err = u.listeners.adopt(key, l)
+			}
+			if err != nil {
+				return fmt.Errorf("gracefully: unable to inherit listener %s: %w", key, err)
+			}
+		}
+	}
+	if fd := os.Getenv(EnvReadyFD); fd != "" {
+		n, err := strconv.Atoi(fd)
+		if err != nil {
+			return fmt.Errorf("gracefully: invalid %s: %w", EnvReadyFD, err)
+		}
+		u.ready = os.NewFile(uintptr(n), "gracefully-ready")
+	}
+	// Our own children should not think they inherited anything from us unless we upgrade
+	_ = os.Unsetenv(EnvListeners)
+	_ = os.Unsetenv(EnvReadyFD)
+	return nil
+}
+
+// Ready tells the parent process, if any, that this process is ready to take over. The parent will then stop.
+// Call this once the routine has claimed its sockets with Listen. Calling Ready when not started by an upgrade does nothing
+func (u *ExecUpgrader) Ready() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.ready == nil {
+		return nil
+	}
+	// Any sockets our parent sent that we did not want are closed so that they are released
+	u.listeners.closeAdopted()
+	_, err := u.ready.Write([]byte{1})
+	closeErr := u.ready.Close()
+	u.ready = nil
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// Upgrade starts a new copy of the current binary, passing it the listeners created with Listen, and waits for it to call Ready.
+// If the new process does not become ready within ReadyTimeout, it's killed and an error is returned
+func (u *ExecUpgrader) Upgrade() (err error) {
+	defer func() {
+		if err != nil && u.opts.OnError != nil {
+			u.opts.OnError(err)
+		}
+	}()
+
+	u.mu.Lock()
+	if u.upgrading {
+		u.mu.Unlock()
+		return ErrUpgradeInProgress
+	}
+	u.upgrading = true
+	u.mu.Unlock()
+	defer func() {
+		u.mu.Lock()
+		u.upgrading = false
+		u.mu.Unlock()
+	}()
+	keys, files, err := u.listeners.files()
+	// The new process has its own copies of the files, we must close ours either way
+	defer closeFiles(files)
+	if err != nil {
+		return err
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = readyR.Close() }()
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = append(files, readyW)
+	cmd.Env = append(upgradeEnviron(),
+		EnvListeners+"="+strings.Join(keys, ","),
+		EnvReadyFD+"="+strconv.Itoa(firstExtraFD+len(files)),
+	)
+	err = cmd.Start()
+	// Only the child should hold the write end, otherwise we'd never see EOF if it dies
+	_ = readyW.Close()
+	if err != nil {
+		return err
+	}
+
+	// Wait for the child to write to the pipe. If it closes it without writing (e.g. it died), we get an error
+	readyChan := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1)
+		_, readErr := readyR.Read(buf)
+		readyChan <- readErr
+	}()
+	// Reap the child whenever it exits so that it does not become a zombie
+	exitChan := make(chan error, 1)
+	go func() {
+		exitChan <- cmd.Wait()
+	}()
+
+	timer := time.NewTimer(u.opts.ReadyTimeout)
+	defer timer.Stop()
+	select {
+	case readErr := <-readyChan:
+		if readErr != nil {
+			_ = cmd.Process.Kill()
+			return ErrUpgradeChildExited
+		}
+	case <-exitChan:
+		return ErrUpgradeChildExited
+	case <-timer.C:
+		_ = cmd.Process.Kill()
+		return ErrUpgradeTimeout
+	}
+
+	// The child owns the sockets now, closing ours must not remove unix socket files from under it
+	u.listeners.keepUnixSocketFiles()
+	return nil
+}
+
+// closeFiles closes all of the files, ignoring errors
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		_ = f.Close()
+	}
+}
+
+// upgradeEnviron is the current environment without any of the variables used to hand over to the new process
+func upgradeEnviron() []string {
+	env := os.Environ()
+	out := env[:0:0]
+	for _, kv := range env {
+		if strings.HasPrefix(kv, EnvListeners+"=") || strings.HasPrefix(kv, EnvReadyFD+"=") {
+			continue
+		}
+		out = append(out, kv)
+	}
+	return out
+}
+
+// UpgradeSignals creates a new Signals SignalSelecter pre-configured like DefaultSignals, plus:
+// SIGUSR2 = GracefulUpgrade
+func UpgradeSignals() *Signals {
+	sigs := make(map[os.Signal]GracefulAction, len(defaultSignals)+1)
+	for sig, action := range defaultSignals {
+		sigs[sig] = action
+	}
+	sigs[syscall.SIGUSR2] = GracefulUpgrade
+	s := NewSignals(sigs)
+	s.SetEscalation(defaultEscalation)
+	return s
+}
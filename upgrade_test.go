@@ -0,0 +1,192 @@
+//go:build !windows
+// +build !windows
+
+package gracefully
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+const (
+	// envUpgradeHelper makes the test binary act as the new process of an upgrade instead of running the tests: ready,
+	// hang or exit
+	envUpgradeHelper = "GRACEFULLY_TEST_UPGRADE"
+	// envUpgradeAddr is the address of the listener the helper should inherit
+	envUpgradeAddr = "GRACEFULLY_TEST_UPGRADE_ADDR"
+	// envUpgradePIDFile is where the helper writes its pid
+	envUpgradePIDFile = "GRACEFULLY_TEST_UPGRADE_PIDFILE"
+)
+
+func TestMain(m *testing.M) {
+	if mode := os.Getenv(envUpgradeHelper); mode != "" {
+		os.Exit(upgradeHelper(mode))
+	}
+	os.Exit(m.Run())
+}
+
+// upgradeHelper is what the test binary does when it's re-executed by ExecUpgrader.Upgrade
+func upgradeHelper(mode string) int {
+	if path := os.Getenv(envUpgradePIDFile); path != "" {
+		if err := ioutil.WriteFile(path, []byte(strconv.Itoa(os.Getpid())), 0600); err != nil {
+			return 1
+		}
+	}
+	switch mode {
+	case "exit":
+		return 3
+	case "hang":
+		time.Sleep(time.Minute)
+		return 0
+	}
+
+	fail := func(what string, err error) int {
+		fmt.Fprintln(os.Stderr, "upgrade helper:", what, err)
+		return 1
+	}
+	listeners := NewListeners()
+	u, err := NewExecUpgrader(listeners, ExecUpgraderOptions{})
+	if err != nil {
+		return fail("inherit", err)
+	}
+	// What was handed over is not handed down to our own children
+	if os.Getenv(EnvListeners) != "" || os.Getenv(EnvReadyFD) != "" {
+		return fail("environment left over", nil)
+	}
+	// Asked for the way the parent did, a new socket would get another port
+	l, err := listeners.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return fail("listen", err)
+	}
+	if l.Addr().String() != os.Getenv(envUpgradeAddr) {
+		return fail("not the inherited socket: "+l.Addr().String(), nil)
+	}
+	if err = u.Ready(); err != nil {
+		return fail("ready", err)
+	}
+	conn, err := l.Accept()
+	if err != nil {
+		return fail("accept", err)
+	}
+	_, _ = conn.Write([]byte("inherited"))
+	_ = conn.Close()
+	_ = listeners.Close()
+	return 0
+}
+
+// setUpgradeHelper makes the next Upgrade start the helper in mode, until the returned func is called
+func setUpgradeHelper(t *testing.T, mode, addr, pidFile string) func() {
+	t.Helper()
+	vars := map[string]string{envUpgradeHelper: mode, envUpgradeAddr: addr, envUpgradePIDFile: pidFile}
+	for k, v := range vars {
+		if err := os.Setenv(k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return func() {
+		for k := range vars {
+			_ = os.Unsetenv(k)
+		}
+	}
+}
+
+func TestExecUpgrader_ChildInheritsListener(t *testing.T) {
+	listeners := NewListeners()
+	defer listeners.Close()
+	l, err := listeners.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	u, err := NewExecUpgrader(listeners, ExecUpgraderOptions{ReadyTimeout: 30 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer setUpgradeHelper(t, "ready", addr, "")()
+
+	if err = u.Upgrade(); err != nil {
+		t.Fatal("expected the new process to become ready, got: ", err)
+	}
+	// We are not accepting, only the new process can answer
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	got, err := ioutil.ReadAll(conn)
+	if err != nil || string(got) != "inherited" {
+		t.Error("expected the new process to accept on the inherited listener, got: ", string(got), err)
+	}
+}
+
+func TestExecUpgrader_KillsChildOnReadyTimeout(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	var reported error
+	u, err := NewExecUpgrader(NewListeners(), ExecUpgraderOptions{
+		ReadyTimeout: 500 * time.Millisecond,
+		OnError:      func(err error) { reported = err },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer setUpgradeHelper(t, "hang", "", pidFile)()
+
+	if err = u.Upgrade(); !errors.Is(err, ErrUpgradeTimeout) {
+		t.Fatal("expected ErrUpgradeTimeout, got: ", err)
+	}
+	if reported != err {
+		t.Error("expected OnError to be told, got: ", reported)
+	}
+	data, err := ioutil.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, _ := strconv.Atoi(string(data))
+	deadline := time.Now().Add(5 * time.Second)
+	for syscall.Kill(pid, 0) == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the new process to be killed and reaped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestExecUpgrader_ChildExitsBeforeReady(t *testing.T) {
+	u, err := NewExecUpgrader(NewListeners(), ExecUpgraderOptions{ReadyTimeout: 30 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer setUpgradeHelper(t, "exit", "", "")()
+	if err = u.Upgrade(); !errors.Is(err, ErrUpgradeChildExited) {
+		t.Error("expected ErrUpgradeChildExited, got: ", err)
+	}
+}
+
+func TestExecUpgrader_ReadyWithoutParent(t *testing.T) {
+	u, err := NewExecUpgrader(NewListeners(), ExecUpgraderOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = u.Ready(); err != nil {
+		t.Error("expected Ready to do nothing when not started by an upgrade, got: ", err)
+	}
+}
+
+func TestUpgradeSignals_EscalateLikeDefaultSignals(t *testing.T) {
+	sigs := UpgradeSignals()
+	defer sigs.Cancel()
+	sigs.mu.Lock()
+	defer sigs.mu.Unlock()
+	if sigs.escalation != defaultEscalation {
+		t.Error("expected the default escalation, got: ", sigs.escalation)
+	}
+}